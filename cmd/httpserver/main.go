@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"hash"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -11,22 +15,32 @@ import (
 	"strings"
 	"syscall"
 
+	"github.com/jsleep/httpfromtcp/internal/cache"
+	"github.com/jsleep/httpfromtcp/internal/compress"
 	"github.com/jsleep/httpfromtcp/internal/headers"
+	"github.com/jsleep/httpfromtcp/internal/proxy"
 	"github.com/jsleep/httpfromtcp/internal/request"
 	"github.com/jsleep/httpfromtcp/internal/response"
 	"github.com/jsleep/httpfromtcp/internal/server"
+	"github.com/jsleep/httpfromtcp/internal/static"
+	"github.com/jsleep/httpfromtcp/internal/websocket"
 )
 
 const port = 42069
 
 func main() {
-	server, err := server.Serve(port, myHandler)
+	server := &server.Server{Port: port, Name: "httpfromtcp"}
+	err := server.Start(compress.Middleware(newRouter().Serve))
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
 	defer server.Close()
 	log.Println("Server started on port", port)
 
+	ctx, stopHealthChecks := context.WithCancel(context.Background())
+	defer stopHealthChecks()
+	go httpbinProxy.HealthCheck(ctx)
+
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
@@ -39,94 +53,141 @@ type HandlerError struct {
 	Message    string
 }
 
-func proxyHandler(w response.Writer, req *request.Request) {
-	base_url := "http://httpbin.org"
-	x := strings.TrimPrefix(req.RequestLine.RequestTarget, "/httpbin/")
+// httpbinPool spreads requests over httpbin.org's plain and TLS endpoints,
+// checking each with /status/200. Its state is served on /admin/pool.
+var httpbinPool = newHTTPBinPool()
+
+// httpbinProxy forwards everything under /httpbin/ to httpbinPool, keeping
+// what it's allowed to in a 32 MB cache. Every body is followed by
+// X-Content-Sha256 and X-Content-Length trailers.
+var httpbinProxy = &proxy.Proxy{
+	Pool:           httpbinPool,
+	StripPrefix:    "/httpbin",
+	Cache:          cache.New(cache.NewMemoryStore(32 << 20)),
+	ModifyResponse: checksumTrailers,
+}
+
+// checksumTrailers hashes resp's body as it streams through and sends the
+// digest and length as trailers.
+func checksumTrailers(resp *http.Response, req *request.Request) {
+	if resp.StatusCode == 101 {
+		return
+	}
+	if resp.Trailer == nil {
+		resp.Trailer = http.Header{}
+	}
+	// declared now so they're announced, filled in at the end of the body
+	resp.Trailer["X-Content-Sha256"] = nil
+	resp.Trailer["X-Content-Length"] = nil
+	resp.Body = &checksumBody{ReadCloser: resp.Body, hash: sha256.New(), trailer: resp.Trailer}
+}
+
+type checksumBody struct {
+	io.ReadCloser
+	hash    hash.Hash
+	length  int64
+	trailer http.Header
+}
 
-	full_url := base_url + "/" + x
-	log.Printf("Proxying request to %s", full_url)
+func (b *checksumBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.hash.Write(p[:n])
+	b.length += int64(n)
+	if err == io.EOF {
+		b.trailer.Set("X-Content-Sha256", hex.EncodeToString(b.hash.Sum(nil)))
+		b.trailer.Set("X-Content-Length", strconv.FormatInt(b.length, 10))
+	}
+	return n, err
+}
 
-	proxy_headers := response.GetDefaultHeaders(0)
-	delete(proxy_headers, "Content-Length") // Remove Content-Length to avoid conflicts
-	proxy_headers["Transfer-Encoding"] = "chunked"
-	proxy_headers["Trailer"] = "X-Content-Sha256, X-Content-Length"
+func newHTTPBinPool() *proxy.Pool {
+	pool, err := proxy.NewPool("http://httpbin.org", "https://httpbin.org")
+	if err != nil {
+		log.Fatalf("Error creating httpbin pool: %v", err)
+	}
+	pool.HealthCheckPath = "/status/200"
+	return pool
+}
 
-	resp, err := http.Get(full_url)
+// localOnly serves next to clients on this machine only, for admin
+// endpoints that shouldn't be public.
+func localOnly(next server.Handler) server.Handler {
+	return func(w response.Writer, req *request.Request) *server.HandlerError {
+		host, _, err := net.SplitHostPort(req.RemoteAddr)
+		if ip := net.ParseIP(host); err != nil || ip == nil || !ip.IsLoopback() {
+			return &server.HandlerError{Code: 403, Message: "Forbidden"}
+		}
+		return next(w, req)
+	}
+}
 
+func videoHandler(w response.Writer, req *request.Request) {
+	f, err := os.Open("assets/vim.mp4")
 	if err != nil {
-		log.Printf("Error fetching %s: %v", full_url, err)
+		log.Printf("Error opening video file: %v", err)
 		w.WriteStatusLine(500)
-		w.WriteHeaders(proxy_headers)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
 		w.WriteBody([]byte("Error: " + err.Error()))
 		return
 	}
+	defer f.Close()
 
-	if resp.StatusCode != 200 {
-		log.Printf("Received non-200 response: %d", resp.StatusCode)
+	info, err := f.Stat()
+	if err != nil {
+		log.Printf("Error reading video file: %v", err)
 		w.WriteStatusLine(500)
-		w.WriteHeaders(proxy_headers)
-		w.WriteBody([]byte("Error: " + resp.Status))
+		w.WriteHeaders(response.GetDefaultHeaders(0))
 		return
 	}
 
-	log.Printf("Received response from %s: %d", full_url, resp.StatusCode)
-	w.WriteStatusLine(200)
-	w.WriteHeaders(proxy_headers)
-
-	bufferSize := 1024 // 1 KB buffer size
-	buffer := make([]byte, bufferSize)
-	buffering := true
-	bytesRead := 0
-	bytesWritten := 0
-
-	for buffering {
-		n, _ := resp.Body.Read(buffer[bytesRead:])
-
-		if n == 0 {
-			buffering = false
-			break
-		}
-
-		bytesRead += n
-		w.WriteChunkedBody(buffer[bytesWritten:bytesRead])
-
-		bytesWritten += n
-
-		if bytesRead == len(buffer) {
-			// If the buffer is full, double its size
-			newBuffer := make([]byte, len(buffer)*2)
-			copy(newBuffer, buffer)
-			buffer = newBuffer
-		}
-
+	err = response.ServeContent(&w, req, info.Name(), info.ModTime(), f, headers.Headers{"Content-Type": "video/mp4"})
+	if err != nil {
+		log.Printf("Error serving video file: %v", err)
 	}
-	resp.Body.Close()
-	w.WriteChunkedBodyDone()
-	trailers := headers.NewHeaders()
+}
 
-	hashBytes := sha256.Sum256(buffer[:bytesWritten])
+var assetHandler = &static.Handler{
+	Root:      static.DirFS("assets"),
+	Prefix:    "/assets",
+	IndexFile: "index.html",
+}
 
-	trailers["X-Content-Sha256"] = hex.EncodeToString(hashBytes[:])
-	trailers["X-Content-Length"] = strconv.Itoa(bytesWritten)
-	w.WriteTrailers(trailers)
+var echoUpgrader = &websocket.Upgrader{
+	EnableCompression: true,
+	MaxMessageSize:    1 << 20, // 1 MB
 }
 
-func videoHandler(w response.Writer, req *request.Request) {
-	body, err := os.ReadFile("assets/vim.mp4")
+// echoHandler sends every WebSocket message back to the client.
+func echoHandler(w response.Writer, req *request.Request) *server.HandlerError {
+	conn, err := echoUpgrader.Upgrade(&w, req)
 	if err != nil {
-		log.Printf("Error reading video file: %v", err)
-		w.WriteStatusLine(500)
-		w.WriteHeaders(response.GetDefaultHeaders(0))
-		w.WriteBody([]byte("Error: " + err.Error()))
-		return
+		log.Printf("Error upgrading to websocket: %v", err)
+		return nil
 	}
 
-	headers := response.GetDefaultHeaders(len(body))
-	headers["Content-Type"] = "video/mp4"
+	for {
+		messageType, message, err := conn.ReadMessage()
+		if err != nil {
+			log.Printf("Websocket closed: %v", err)
+			return nil
+		}
+		if err := conn.WriteMessage(messageType, message); err != nil {
+			log.Printf("Error writing websocket message: %v", err)
+			conn.Close(websocket.CloseInternalError, "")
+			return nil
+		}
+	}
+}
 
-	w.WriteStatusLine(200)
-	w.WriteHeaders(headers)
-	w.WriteBody(body)
+// newRouter registers myHandler for GET on every path, the WebSocket echo
+// on /echo and the httpbin pool's state on /admin/pool, for local clients. The router answers OPTIONS and unsupported methods itself,
+// and the server takes care of HEAD.
+func newRouter() *server.Router {
+	router := server.NewRouter()
+	router.Handle("GET", "/", myHandler)
+	router.Handle("GET", "/echo", echoHandler)
+	router.Handle("GET", "/admin/pool", localOnly(httpbinPool.AdminHandler))
+	return router
 }
 
 var myHandler = server.Handler(func(w response.Writer, req *request.Request) *server.HandlerError {
@@ -136,9 +197,10 @@ var myHandler = server.Handler(func(w response.Writer, req *request.Request) *se
 	if req.RequestLine.RequestTarget == "/video" {
 		videoHandler(w, req)
 		return nil
+	} else if strings.HasPrefix(req.RequestLine.RequestTarget, "/assets/") {
+		return assetHandler.Serve(w, req)
 	} else if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin/") {
-		proxyHandler(w, req)
-		return nil
+		return httpbinProxy.Serve(w, req)
 	} else if req.RequestLine.RequestTarget == "/yourproblem" {
 		code = 400
 		body =
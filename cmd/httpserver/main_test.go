@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jsleep/httpfromtcp/internal/compress"
+	"github.com/jsleep/httpfromtcp/internal/proxy"
+	"github.com/jsleep/httpfromtcp/internal/request"
+	"github.com/jsleep/httpfromtcp/internal/response"
+	"github.com/jsleep/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// roundTrip sends a raw request to srv and returns everything it sends back
+// before closing the connection.
+func roundTrip(t *testing.T, srv *server.Server, raw string) string {
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(raw))
+	require.NoError(t, err)
+	resp, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	return string(resp)
+}
+
+func startServer(t *testing.T) *server.Server {
+	srv, err := server.Serve(0, compress.Middleware(newRouter().Serve))
+	require.NoError(t, err)
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+func splitResponse(t *testing.T, resp string) (string, string) {
+	head, body, found := strings.Cut(resp, "\r\n\r\n")
+	require.True(t, found, "no end of headers in %q", resp)
+	return head, body
+}
+
+// withoutDate drops the Date header, which can tick over between requests.
+func withoutDate(lines []string) []string {
+	var kept []string
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "Date: ") {
+			kept = append(kept, line)
+		}
+	}
+	return kept
+}
+
+func TestHead(t *testing.T) {
+	srv := startServer(t)
+
+	for _, path := range []string{"/", "/yourproblem", "/myproblem"} {
+		getHead, getBody := splitResponse(t, roundTrip(t, srv, "GET "+path+" HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		headHead, headBody := splitResponse(t, roundTrip(t, srv, "HEAD "+path+" HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+
+		// Test: same status line and headers as GET, including Content-Length
+		getLines := withoutDate(strings.Split(getHead, "\r\n"))
+		headLines := withoutDate(strings.Split(headHead, "\r\n"))
+		assert.Equal(t, getLines[0], headLines[0])
+		assert.ElementsMatch(t, getLines[1:], headLines[1:])
+		assert.Contains(t, headHead, "Content-Length: ")
+
+		// Test: but no body
+		assert.NotEmpty(t, getBody)
+		assert.Empty(t, headBody)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	srv := startServer(t)
+
+	// Test: OPTIONS on a path lists its methods
+	head, body := splitResponse(t, roundTrip(t, srv, "OPTIONS /yourproblem HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 204 No Content\r\n"))
+	assert.Contains(t, head, "Allow: GET, HEAD, OPTIONS")
+	assert.Empty(t, body)
+
+	// Test: OPTIONS * lists every method the server handles
+	head, _ = splitResponse(t, roundTrip(t, srv, "OPTIONS * HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 204 No Content\r\n"))
+	assert.Contains(t, head, "Allow: GET, HEAD, OPTIONS")
+
+	// Test: other methods get a 405 with Allow
+	head, _ = splitResponse(t, roundTrip(t, srv, "DELETE / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 405 Method Not Allowed\r\n"))
+	assert.Contains(t, head, "Allow: GET, HEAD, OPTIONS")
+}
+
+func TestDefaultHeaders(t *testing.T) {
+	srv := &server.Server{Name: "httpfromtcp"}
+	require.NoError(t, srv.Start(newRouter().Serve))
+	t.Cleanup(func() { srv.Close() })
+
+	// Test: Date and Server are added and header names are canonical
+	head, _ := splitResponse(t, roundTrip(t, srv, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	assert.Regexp(t, `\r\nDate: \w{3}, \d{2} \w{3} \d{4} \d{2}:\d{2}:\d{2} GMT`, head)
+	assert.Contains(t, head, "\r\nServer: httpfromtcp")
+	assert.Contains(t, head, "\r\nContent-Type: text/html")
+
+	// Test: both can be turned off
+	plain := &server.Server{OmitDate: true}
+	require.NoError(t, plain.Start(newRouter().Serve))
+	t.Cleanup(func() { plain.Close() })
+	head, _ = splitResponse(t, roundTrip(t, plain, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	assert.NotContains(t, head, "Date: ")
+	assert.NotContains(t, head, "Server: ")
+}
+
+func TestEchoHandshake(t *testing.T) {
+	srv := startServer(t)
+
+	// Test: /echo switches to WebSocket, everything else stays HTTP
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET /echo HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"))
+	require.NoError(t, err)
+	head := make([]byte, len("HTTP/1.1 101 Switching Protocols"))
+	_, err = io.ReadFull(conn, head)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols", string(head))
+
+	// Test: a plain GET to /echo is refused
+	plainHead, _ := splitResponse(t, roundTrip(t, srv, "GET /echo HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	assert.True(t, strings.HasPrefix(plainHead, "HTTP/1.1 400 Bad Request\r\n"))
+}
+
+func TestAdminPool(t *testing.T) {
+	srv := startServer(t)
+
+	// Test: the httpbin pool's state is served to local clients
+	head, body := splitResponse(t, roundTrip(t, srv, "GET /admin/pool HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, body, `"available":"2/2"`)
+	assert.Contains(t, body, `"url":"https://httpbin.org"`)
+
+	// Test: and refused to anyone else
+	req, err := request.RequestFromReader(strings.NewReader("GET /admin/pool HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	req.RemoteAddr = "203.0.113.7:51234"
+	handlerErr := localOnly(httpbinPool.AdminHandler)(response.Writer{Writer: io.Discard}, req)
+	require.NotNil(t, handlerErr)
+	assert.Equal(t, 403, int(handlerErr.Code))
+}
+
+func TestChecksumTrailers(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"origin": "127.0.0.1"}`))
+	}))
+	defer upstream.Close()
+	p, err := proxy.New(upstream.URL)
+	require.NoError(t, err)
+	p.StripPrefix = "/httpbin"
+	p.ModifyResponse = checksumTrailers
+	srv, err := server.Serve(0, compress.Middleware(p.Serve))
+	require.NoError(t, err)
+	t.Cleanup(func() { srv.Close() })
+
+	// Test: the proxied body is followed by its digest and length
+	resp, err := http.Get("http://" + srv.Listener.Addr().String() + "/httpbin/get")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	// net/http moves the announced names from the Trailer header to resp.Trailer
+	assert.Contains(t, resp.Trailer, "X-Content-Sha256")
+	assert.Contains(t, resp.Trailer, "X-Content-Length")
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	sum := sha256.Sum256(body)
+	assert.Equal(t, hex.EncodeToString(sum[:]), resp.Trailer.Get("X-Content-Sha256"))
+	assert.Equal(t, strconv.Itoa(len(body)), resp.Trailer.Get("X-Content-Length"))
+}
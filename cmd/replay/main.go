@@ -0,0 +1,132 @@
+// replay sends the requests in a capture written by cmd/tcplistener to a
+// server and reports the ones whose status code isn't what was recorded.
+//
+//	replay -target http://localhost:8080 -concurrency 4 -rate 20 capture.jsonl
+//
+// A capture taken without -upstream has no statuses to compare with, so
+// -baseline sends every request to a second server as well and compares
+// the target's statuses with that one's instead:
+//
+//	replay -baseline http://prod:8080 -target http://candidate:8080 capture.jsonl
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+
+	"github.com/jsleep/httpfromtcp/internal/capture"
+)
+
+func main() {
+	target := flag.String("target", "http://localhost:42069", "URL of the server to replay against")
+	baseline := flag.String("baseline", "", "URL of a server whose statuses the target's are compared with, instead of the recorded ones")
+	concurrency := flag.Int("concurrency", 1, "requests in flight at once")
+	rate := flag.Float64("rate", 0, "requests started per second, 0 for no limit")
+	keepHost := flag.Bool("keep-host", false, "send the captured Host header instead of the target's")
+	verbose := flag.Bool("v", false, "print every request, not just the ones whose status changed")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: replay [flags] capture.jsonl")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	u, err := url.Parse(*target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		log.Fatalf("Invalid target URL: %s", *target)
+	}
+	var base *url.URL
+	if *baseline != "" {
+		base, err = url.Parse(*baseline)
+		if err != nil || (base.Scheme != "http" && base.Scheme != "https") {
+			log.Fatalf("Invalid baseline URL: %s", *baseline)
+		}
+	}
+	records, err := readCapture(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("Error reading capture: %v", err)
+	}
+	if base == nil && !hasStatuses(records) {
+		log.Println("Warning: the capture has no recorded statuses, so nothing can change; use -baseline to compare with another server")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	replayer := &capture.Replayer{Target: u, Baseline: base, Concurrency: *concurrency, Rate: *rate, KeepHost: *keepHost}
+	summary := replayer.Run(ctx, records, func(r capture.Result) {
+		switch {
+		case r.Err != nil:
+			fmt.Printf("ERROR %s %s: %v\n", r.Record.Method, r.Record.Target, r.Err)
+		case r.Changed():
+			fmt.Printf("%d -> %d %s %s\n", r.Expected, r.Status, r.Record.Method, r.Record.Target)
+		case *verbose:
+			fmt.Printf("%d %s %s (%v)\n", r.Status, r.Record.Method, r.Record.Target, r.Latency)
+		}
+	})
+
+	fmt.Printf("\n%d sent, %d skipped, %d errors in %v\n", summary.Sent, summary.Skipped, summary.Errors, summary.Duration.Round(1e6))
+	fmt.Printf("%d matched, %d changed status, %d unchecked\n", summary.Matched, summary.Changed, summary.Unchecked)
+	changes := make([]capture.StatusChange, 0, len(summary.Changes))
+	for change := range summary.Changes {
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].From != changes[j].From {
+			return changes[i].From < changes[j].From
+		}
+		return changes[i].To < changes[j].To
+	})
+	for _, change := range changes {
+		fmt.Printf("- %d -> %d: %d\n", change.From, change.To, summary.Changes[change])
+	}
+	if summary.Changed > 0 || summary.Errors > 0 {
+		os.Exit(1)
+	}
+}
+
+// readCapture loads every record in the file at path, skipping lines that
+// aren't records with a warning.
+func readCapture(path string) ([]capture.Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []capture.Record
+	reader := capture.NewReader(f)
+	for {
+		rec, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if errors.Is(err, capture.ErrInvalidRecord) {
+			fmt.Println("Skipping", err)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+}
+
+// hasStatuses reports whether any record has a status to compare with.
+func hasStatuses(records []capture.Record) bool {
+	for _, rec := range records {
+		if rec.Status != 0 {
+			return true
+		}
+	}
+	return false
+}
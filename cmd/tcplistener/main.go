@@ -1,21 +1,60 @@
+// tcplistener parses the requests sent to it, prints them and records each
+// one, with when it arrived and who from, to a JSON Lines capture that
+// cmd/replay can send again. With -upstream every request is also passed on
+// to that server and the status it answers with is recorded; without it
+// every request gets an empty 200 and no status is recorded, so replaying
+// the capture needs cmd/replay's -baseline to have something to compare.
 package main
 
 import (
+	"bytes"
+	"flag"
 	"fmt"
+	"io"
+	"log"
 	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
 
+	"github.com/jsleep/httpfromtcp/internal/capture"
+	"github.com/jsleep/httpfromtcp/internal/client"
+	"github.com/jsleep/httpfromtcp/internal/headers"
 	"github.com/jsleep/httpfromtcp/internal/request"
+	"github.com/jsleep/httpfromtcp/internal/response"
 )
 
 func main() {
+	addr := flag.String("addr", ":42069", "address to listen on")
+	out := flag.String("out", "capture.jsonl", "file the capture is appended to")
+	upstream := flag.String("upstream", "", "URL of a server to pass requests on to, e.g. http://localhost:8080")
+	keepHost := flag.Bool("keep-host", false, "pass on the client's Host header instead of the upstream's")
+	flag.Parse()
 
-	ln, err := net.Listen("tcp", ":42069")
+	var target *url.URL
+	if *upstream != "" {
+		u, err := url.Parse(*upstream)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			log.Fatalf("Invalid upstream URL: %s", *upstream)
+		}
+		target = u
+	}
+
+	f, err := os.OpenFile(*out, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
 	if err != nil {
-		panic(err)
+		log.Fatalf("Error opening capture file: %v", err)
+	}
+	defer f.Close()
+	records := capture.NewWriter(f)
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatalf("Error listening: %v", err)
 	}
 	defer ln.Close()
+	log.Println("Capturing requests on", ln.Addr(), "to", *out)
 
-	// Read the file
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
@@ -23,24 +62,90 @@ func main() {
 			continue
 		}
 		fmt.Println("accepted connection")
-		req, err := request.RequestFromReader(conn)
-		if err != nil {
-			panic(err)
-		}
-		fmt.Println("Request line:")
-		fmt.Printf("- Method: %s\n", req.RequestLine.Method)
-		fmt.Printf("- Target: %s\n", req.RequestLine.RequestTarget)
-		fmt.Printf("- Version: %s\n", req.RequestLine.HttpVersion)
-		fmt.Println("Headers:")
-		for key, value := range req.Headers {
-			fmt.Printf("- %s: %s\n", key, value)
-		}
-		fmt.Println("Body:")
-		if len(req.Body) > 0 {
-			fmt.Println(string(req.Body))
-		} else {
-			fmt.Println("(no body)")
-		}
+		go handle(conn, records, target, *keepHost)
 	}
+}
+
+// handle reads one request from conn, records it and answers it, then
+// closes the connection.
+func handle(conn net.Conn, records *capture.Writer, upstream *url.URL, keepHost bool) {
+	defer conn.Close()
 
+	started := time.Now()
+	req, err := request.RequestFromReader(conn)
+	received := time.Now()
+	if err != nil {
+		fmt.Println("Error parsing request:", err)
+		record(records, capture.Record{Started: started, Received: received, Remote: conn.RemoteAddr().String(), Error: err.Error()})
+		reply(conn, 400, headers.NewHeaders(), nil, []byte(err.Error()+"\n"))
+		return
+	}
+	req.Print()
+	rec := capture.FromRequest(req, conn.RemoteAddr().String(), started, received)
+
+	if upstream == nil {
+		record(records, rec)
+		reply(conn, 200, headers.NewHeaders(), nil, nil)
+		return
+	}
+
+	status, h, cookies, body, err := forward(rec, upstream, keepHost)
+	if err != nil {
+		fmt.Println("Error forwarding request:", err)
+		rec.Error = err.Error()
+		record(records, rec)
+		reply(conn, 502, headers.NewHeaders(), nil, []byte(err.Error()+"\n"))
+		return
+	}
+	rec.Status = status
+	record(records, rec)
+	reply(conn, response.StatusCode(status), h, cookies, body)
+}
+
+func record(records *capture.Writer, rec capture.Record) {
+	if err := records.Write(rec); err != nil {
+		fmt.Println("Error writing capture:", err)
+	}
+}
+
+// forward sends rec to upstream and returns the whole response.
+func forward(rec capture.Record, upstream *url.URL, keepHost bool) (int, headers.Headers, []string, []byte, error) {
+	req, err := rec.Request(upstream, keepHost)
+	if err != nil {
+		return 0, nil, nil, nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, nil, nil, err
+	}
+	return int(resp.StatusLine.StatusCode), resp.Headers, resp.SetCookies, body, nil
+}
+
+// reply writes a response that closes the connection, with body framed by
+// its length whatever framing h came with.
+func reply(conn net.Conn, status response.StatusCode, h headers.Headers, cookies []string, body []byte) {
+	h.Delete("Transfer-Encoding")
+	h.Delete("Trailer")
+	h.Delete("Keep-Alive")
+	// folded together by the parser, so sent from cookies instead
+	h.Delete("Set-Cookie")
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	h.Set("Connection", "close")
+
+	var b bytes.Buffer
+	w := response.Writer{Writer: &b}
+	w.WriteStatusLine(status)
+	for _, c := range cookies {
+		w.AddSetCookie(c)
+	}
+	w.WriteHeaders(h)
+	w.WriteBody(body)
+	if _, err := b.WriteTo(conn); err != nil {
+		fmt.Println("Error writing response:", err)
+	}
 }
@@ -0,0 +1,526 @@
+// Package cache is an HTTP cache shared by every client of a proxy, as
+// described by RFC 9111. It sits in front of the proxy's transport.
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxEntrySize is the largest response body a Cache keeps unless
+// told otherwise.
+const DefaultMaxEntrySize = 1 << 20 // 1 MB
+
+// Every response through a Cache says how it was served in its X-Cache
+// header.
+const (
+	// Hit was served from the cache.
+	Hit = "HIT"
+	// Miss came from upstream.
+	Miss = "MISS"
+	// Revalidated was served from the cache after upstream confirmed it's
+	// still current.
+	Revalidated = "REVALIDATED"
+	// Stale was served from the cache past its freshness, as allowed by
+	// stale-while-revalidate or the client's max-stale.
+	Stale = "STALE"
+	// Bypass came from upstream without the cache being looked at, e.g.
+	// for a POST or a Range request.
+	Bypass = "BYPASS"
+)
+
+// entry is a stored response. An entry whose response had a Vary header is
+// stored under the variant's key, and under the plain key an entry with
+// just Vary set says which request headers pick the variant. That marker
+// lists the keys of its Variants so they can all be invalidated together.
+type entry struct {
+	Vary     []string
+	Variants []string
+
+	Status int
+	Header http.Header
+	Body   []byte
+
+	// RequestTime and ResponseTime are when the request that got this
+	// response was sent and the response received.
+	RequestTime  time.Time
+	ResponseTime time.Time
+}
+
+func decodeEntry(value []byte) (*entry, error) {
+	var e entry
+	if err := gob.NewDecoder(bytes.NewReader(value)).Decode(&e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+func (e *entry) encode() ([]byte, error) {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(e); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// Cache keeps responses in a Store and serves them again while they're
+// fresh.
+type Cache struct {
+	Store Store
+
+	// MaxEntrySize is the largest response body kept, in bytes.
+	MaxEntrySize int64
+
+	now func() time.Time
+
+	mu           sync.Mutex
+	revalidating map[string]bool
+
+	// markers serializes updates to the Vary markers' lists of variants.
+	markers sync.Mutex
+}
+
+func New(store Store) *Cache {
+	return &Cache{
+		Store:        store,
+		MaxEntrySize: DefaultMaxEntrySize,
+		now:          time.Now,
+		revalidating: map[string]bool{},
+	}
+}
+
+// Transport returns a RoundTripper that answers from the cache when it
+// can and makes requests with next when it can't.
+func (c *Cache) Transport(next http.RoundTripper) http.RoundTripper {
+	return &transport{cache: c, next: next}
+}
+
+type transport struct {
+	cache *Cache
+	next  http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c := t.cache
+	if req.Method != "GET" {
+		resp, err := t.next.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		if !isSafe(req.Method) && resp.StatusCode < 400 {
+			c.invalidate(req, resp)
+		}
+		resp.Header.Set("X-Cache", Bypass)
+		return resp, nil
+	}
+
+	rc := parseControl(req.Header)
+	if rc.has("no-store") || req.Header.Get("Range") != "" {
+		resp, err := t.next.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		resp.Header.Set("X-Cache", Bypass)
+		return resp, nil
+	}
+
+	key := cacheKey(req)
+	stored := c.lookup(key, req)
+	if stored == nil {
+		if rc.has("only-if-cached") {
+			return c.gatewayTimeout(req), nil
+		}
+		return t.fetch(req, key)
+	}
+
+	now := c.now()
+	sc := parseControl(stored.Header)
+	age, lifetime := stored.age(now), stored.freshnessLifetime()
+	if maxAge, ok := rc.seconds("max-age"); ok {
+		lifetime = min(lifetime, maxAge)
+	}
+	if minFresh, ok := rc.seconds("min-fresh"); ok {
+		age += minFresh
+	}
+	mustRevalidate := sc.has("must-revalidate") || sc.has("proxy-revalidate") || sc.has("s-maxage")
+	noCache := rc.has("no-cache") || sc.has("no-cache")
+
+	if !noCache && age < lifetime {
+		return c.serve(req, stored, now, Hit), nil
+	}
+	if !noCache && !mustRevalidate {
+		staleness := age - lifetime
+		if window, ok := sc.seconds("stale-while-revalidate"); ok && staleness <= window {
+			go t.revalidate(req.Clone(context.WithoutCancel(req.Context())), key, stored)
+			return c.serve(req, stored, now, Stale), nil
+		}
+		if maxStale, ok := rc["max-stale"]; ok {
+			// a bare max-stale takes any staleness
+			limit, _ := rc.seconds("max-stale")
+			if maxStale == "" || staleness <= limit {
+				return c.serve(req, stored, now, Stale), nil
+			}
+		}
+	}
+	if rc.has("only-if-cached") {
+		return c.gatewayTimeout(req), nil
+	}
+	return t.revalidateNow(req, key, stored)
+}
+
+// isSafe methods don't change anything upstream (RFC 9110 section 9.2.1).
+func isSafe(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return true
+	}
+	return false
+}
+
+// cacheKey names the resource req is for.
+func cacheKey(req *http.Request) string {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	return req.URL.Scheme + "://" + strings.ToLower(host) + req.URL.RequestURI()
+}
+
+// variantKey names the variant of key picked by the vary headers of req.
+func variantKey(key string, vary []string, req *http.Request) string {
+	var b strings.Builder
+	b.WriteString(key)
+	for _, name := range vary {
+		b.WriteString("\x00" + name + ":")
+		values := req.Header.Values(name)
+		for i, value := range values {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			b.WriteString(strings.TrimSpace(value))
+		}
+	}
+	return b.String()
+}
+
+// varyNames lists the request headers named in h's Vary, canonical and in
+// order so every response with the same Vary agrees on variant keys.
+func varyNames(h http.Header) []string {
+	var names []string
+	for _, value := range h.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c *Cache) load(key string) *entry {
+	value, ok, err := c.Store.Get(key)
+	if err != nil {
+		fmt.Println("Error reading cache entry:", err)
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+	e, err := decodeEntry(value)
+	if err != nil {
+		fmt.Println("Error decoding cache entry:", err)
+		c.Store.Delete(key)
+		return nil
+	}
+	return e
+}
+
+// lookup finds the stored response to req, or nil.
+func (c *Cache) lookup(key string, req *http.Request) *entry {
+	e := c.load(key)
+	if e != nil && len(e.Vary) > 0 {
+		e = c.load(variantKey(key, e.Vary, req))
+	}
+	return e
+}
+
+func (c *Cache) save(key string, req *http.Request, e *entry) {
+	if vary := varyNames(e.Header); len(vary) > 0 {
+		variant := variantKey(key, vary, req)
+		c.markers.Lock()
+		marker := &entry{Vary: vary}
+		// keep listing variants stored before, even under another Vary,
+		// so invalidation still finds them
+		if old := c.load(key); old != nil {
+			marker.Variants = old.Variants
+		}
+		if !slices.Contains(marker.Variants, variant) {
+			marker.Variants = append(marker.Variants, variant)
+		}
+		c.saveEntry(key, marker)
+		c.markers.Unlock()
+		key = variant
+	}
+	c.saveEntry(key, e)
+}
+
+// remove deletes the response stored under key, every variant of it
+// included.
+func (c *Cache) remove(key string) {
+	c.markers.Lock()
+	defer c.markers.Unlock()
+	if e := c.load(key); e != nil {
+		for _, variant := range e.Variants {
+			c.Store.Delete(variant)
+		}
+	}
+	c.Store.Delete(key)
+}
+
+func (c *Cache) saveEntry(key string, e *entry) {
+	value, err := e.encode()
+	if err == nil {
+		err = c.Store.Set(key, value)
+	}
+	if err != nil {
+		fmt.Println("Error storing cache entry:", err)
+	}
+}
+
+// invalidate drops what's stored for the target of an unsafe request, and
+// for the same-origin URLs its response says were changed (RFC 9111
+// section 4.4).
+func (c *Cache) invalidate(req *http.Request, resp *http.Response) {
+	c.remove(cacheKey(req))
+	for _, name := range []string{"Location", "Content-Location"} {
+		location, err := req.URL.Parse(resp.Header.Get(name))
+		if err != nil || resp.Header.Get(name) == "" {
+			continue
+		}
+		if location.Scheme == req.URL.Scheme && location.Host == req.URL.Host {
+			c.remove(cacheKey(&http.Request{URL: location}))
+		}
+	}
+}
+
+// serve answers req from e.
+func (c *Cache) serve(req *http.Request, e *entry, now time.Time, how string) *http.Response {
+	h := e.Header.Clone()
+	h.Set("Age", strconv.FormatInt(int64(e.age(now)/time.Second), 10))
+	h.Set("X-Cache", how)
+
+	if notModified(req, e) {
+		h.Del("Content-Length")
+		return newResponse(req, 304, h, nil)
+	}
+	return newResponse(req, e.Status, h, e.Body)
+}
+
+// notModified evaluates the client's own conditional headers against e,
+// If-None-Match taking precedence (RFC 9110 section 13.2.2).
+func notModified(req *http.Request, e *entry) bool {
+	if e.Status != 200 {
+		return false
+	}
+	if ifNoneMatch := req.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		etag := strings.TrimPrefix(e.Header.Get("ETag"), "W/")
+		if etag == "" {
+			return false
+		}
+		for _, candidate := range strings.Split(ifNoneMatch, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(req.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lastModified, err := http.ParseTime(e.Header.Get("Last-Modified"))
+	return err == nil && !lastModified.After(since)
+}
+
+func newResponse(req *http.Request, status int, h http.Header, body []byte) *http.Response {
+	return &http.Response{
+		Status:        strconv.Itoa(status) + " " + http.StatusText(status),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        h,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// gatewayTimeout is the answer to only-if-cached when nothing usable is
+// stored (RFC 9111 section 5.2.1.7).
+func (c *Cache) gatewayTimeout(req *http.Request) *http.Response {
+	h := http.Header{}
+	h.Set("X-Cache", Miss)
+	return newResponse(req, 504, h, nil)
+}
+
+// fetch gets req from upstream, storing the response as it's read if it
+// can be stored.
+func (t *transport) fetch(req *http.Request, key string) (*http.Response, error) {
+	requestTime := t.cache.now()
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	t.cache.record(req, key, resp, requestTime)
+	resp.Header.Set("X-Cache", Miss)
+	return resp, nil
+}
+
+// record arranges for resp to be stored once its body has been read in
+// full, if it can be stored.
+func (c *Cache) record(req *http.Request, key string, resp *http.Response, requestTime time.Time) {
+	if !storable(req, resp) || resp.ContentLength > c.MaxEntrySize {
+		return
+	}
+	e := &entry{
+		Status:       resp.StatusCode,
+		Header:       resp.Header.Clone(),
+		RequestTime:  requestTime,
+		ResponseTime: c.now(),
+	}
+	resp.Body = &recordingBody{
+		ReadCloser: resp.Body,
+		limit:      c.MaxEntrySize,
+		done: func(body []byte) {
+			e.Body = body
+			c.save(key, req, e)
+		},
+	}
+}
+
+// recordingBody keeps a copy of what's read through it and hands it to
+// done at EOF, unless it grew past limit.
+type recordingBody struct {
+	io.ReadCloser
+	limit    int64
+	buffer   bytes.Buffer
+	tooLarge bool
+	done     func(body []byte)
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if !b.tooLarge {
+		if int64(b.buffer.Len()+n) > b.limit {
+			b.tooLarge = true
+			b.buffer = bytes.Buffer{}
+		} else {
+			b.buffer.Write(p[:n])
+		}
+	}
+	if err == io.EOF && !b.tooLarge && b.done != nil {
+		b.done(b.buffer.Bytes())
+		b.done = nil
+	}
+	return n, err
+}
+
+// revalidateNow asks upstream whether stored is still current before
+// answering req.
+func (t *transport) revalidateNow(req *http.Request, key string, stored *entry) (*http.Response, error) {
+	requestTime := t.cache.now()
+	resp, err := t.next.RoundTrip(conditional(req, stored))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == 304 {
+		resp.Body.Close()
+		updated := t.cache.refresh(req, key, stored, resp, requestTime)
+		return t.cache.serve(req, updated, t.cache.now(), Revalidated), nil
+	}
+	t.cache.record(req, key, resp, requestTime)
+	resp.Header.Set("X-Cache", Miss)
+	return resp, nil
+}
+
+// revalidate is revalidateNow in the background for a stale response
+// that's already been served. Only one runs per key at a time.
+func (t *transport) revalidate(req *http.Request, key string, stored *entry) {
+	c := t.cache
+	c.mu.Lock()
+	if c.revalidating[key] {
+		c.mu.Unlock()
+		return
+	}
+	c.revalidating[key] = true
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.revalidating, key)
+		c.mu.Unlock()
+	}()
+
+	requestTime := c.now()
+	resp, err := t.next.RoundTrip(conditional(req, stored))
+	if err != nil {
+		fmt.Println("Error revalidating cache entry:", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == 304 {
+		c.refresh(req, key, stored, resp, requestTime)
+		return
+	}
+	c.record(req, key, resp, requestTime)
+	io.Copy(io.Discard, resp.Body)
+}
+
+// conditional is req asking for the response only if it differs from
+// stored. The client's own conditions are left out: they're checked
+// against the cached response instead.
+func conditional(req *http.Request, stored *entry) *http.Request {
+	out := req.Clone(req.Context())
+	out.Header.Del("If-None-Match")
+	out.Header.Del("If-Modified-Since")
+	if etag := stored.Header.Get("ETag"); etag != "" {
+		out.Header.Set("If-None-Match", etag)
+	}
+	if lastModified := stored.Header.Get("Last-Modified"); lastModified != "" {
+		out.Header.Set("If-Modified-Since", lastModified)
+	}
+	return out
+}
+
+// refresh updates stored with the headers of a 304 and stores it again
+// (RFC 9111 section 3.2).
+func (c *Cache) refresh(req *http.Request, key string, stored *entry, notModified *http.Response, requestTime time.Time) *entry {
+	updated := *stored
+	updated.Header = stored.Header.Clone()
+	for name, values := range notModified.Header {
+		switch name {
+		case "Content-Length", "Content-Encoding", "Content-Range", "Transfer-Encoding", "Connection", "Keep-Alive":
+			continue
+		}
+		updated.Header[name] = values
+	}
+	updated.RequestTime = requestTime
+	updated.ResponseTime = c.now()
+	if storable(req, &http.Response{StatusCode: updated.Status, Header: updated.Header}) {
+		c.save(key, req, &updated)
+	}
+	return &updated
+}
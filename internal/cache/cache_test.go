@@ -0,0 +1,304 @@
+package cache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCache is a cache in memory with a clock the test moves by hand.
+func testCache() (*Cache, *time.Time) {
+	c := New(NewMemoryStore(1 << 20))
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	c.now = func() time.Time { return now }
+	return c, &now
+}
+
+// get sends a GET for url through rt and returns the response with its
+// body read.
+func get(t *testing.T, rt http.RoundTripper, url string, header http.Header) (*http.Response, string) {
+	req, err := http.NewRequest("GET", url, nil)
+	require.NoError(t, err)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := rt.RoundTrip(req)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	return resp, string(body)
+}
+
+func TestParseControl(t *testing.T) {
+	h := http.Header{}
+	h.Add("Cache-Control", `public, max-age=60, no-cache="Set-Cookie, Date"`)
+	h.Add("Cache-Control", "S-MAXAGE=30")
+	d := parseControl(h)
+
+	// Test: directives across fields, quoted commas kept together
+	assert.True(t, d.has("public"))
+	assert.Equal(t, "Set-Cookie, Date", d["no-cache"])
+	maxAge, ok := d.seconds("max-age")
+	assert.True(t, ok)
+	assert.Equal(t, 60*time.Second, maxAge)
+	sMaxAge, _ := d.seconds("s-maxage")
+	assert.Equal(t, 30*time.Second, sMaxAge)
+
+	// Test: Pragma stands in when there's no Cache-Control
+	assert.True(t, parseControl(http.Header{"Pragma": {"no-cache"}}).has("no-cache"))
+}
+
+func TestFreshness(t *testing.T) {
+	date := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	header := func(pairs ...string) http.Header {
+		h := http.Header{"Date": {date.Format(http.TimeFormat)}}
+		for i := 0; i < len(pairs); i += 2 {
+			h.Set(pairs[i], pairs[i+1])
+		}
+		return h
+	}
+
+	// Test: s-maxage over max-age over Expires over the heuristic
+	e := &entry{Status: 200, Header: header("Cache-Control", "max-age=60, s-maxage=30")}
+	assert.Equal(t, 30*time.Second, e.freshnessLifetime())
+	e = &entry{Status: 200, Header: header("Cache-Control", "max-age=60", "Expires", date.Add(time.Hour).Format(http.TimeFormat))}
+	assert.Equal(t, 60*time.Second, e.freshnessLifetime())
+	e = &entry{Status: 200, Header: header("Expires", date.Add(time.Hour).Format(http.TimeFormat))}
+	assert.Equal(t, time.Hour, e.freshnessLifetime())
+	e = &entry{Status: 200, Header: header("Last-Modified", date.Add(-10*time.Hour).Format(http.TimeFormat))}
+	assert.Equal(t, time.Hour, e.freshnessLifetime())
+	e = &entry{Status: 500, Header: header("Last-Modified", date.Add(-10*time.Hour).Format(http.TimeFormat))}
+	assert.Zero(t, e.freshnessLifetime())
+	e = &entry{Status: 200, Header: header("Expires", "0")}
+	assert.Zero(t, e.freshnessLifetime())
+
+	// Test: age counts the Age header, transit time and time stored
+	e = &entry{
+		Header:       header("Age", "10"),
+		RequestTime:  date.Add(-2 * time.Second),
+		ResponseTime: date,
+	}
+	assert.Equal(t, 12*time.Second+time.Minute, e.age(date.Add(time.Minute)))
+}
+
+func TestHitAndMiss(t *testing.T) {
+	var hits atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := hits.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("response " + strconv.Itoa(int(n))))
+	}))
+	defer upstream.Close()
+	c, now := testCache()
+	rt := c.Transport(http.DefaultTransport)
+
+	// Test: the first request goes upstream, the second doesn't
+	resp, body := get(t, rt, upstream.URL+"/a", nil)
+	assert.Equal(t, Miss, resp.Header.Get("X-Cache"))
+	assert.Equal(t, "response 1", body)
+	*now = now.Add(10 * time.Second)
+	resp, body = get(t, rt, upstream.URL+"/a", nil)
+	assert.Equal(t, Hit, resp.Header.Get("X-Cache"))
+	assert.Equal(t, "10", resp.Header.Get("Age"))
+	assert.Equal(t, "response 1", body)
+	assert.Equal(t, int32(1), hits.Load())
+
+	// Test: a different query is a different resource
+	_, body = get(t, rt, upstream.URL+"/a?x=1", nil)
+	assert.Equal(t, "response 2", body)
+
+	// Test: once stale it's fetched again
+	*now = now.Add(time.Minute)
+	resp, body = get(t, rt, upstream.URL+"/a", nil)
+	assert.Equal(t, Miss, resp.Header.Get("X-Cache"))
+	assert.Equal(t, "response 3", body)
+
+	// Test: the client can insist on going upstream
+	resp, _ = get(t, rt, upstream.URL+"/a", http.Header{"Cache-Control": {"no-cache"}})
+	assert.Equal(t, Miss, resp.Header.Get("X-Cache"))
+
+	// Test: an unsafe method drops what's stored
+	req, err := http.NewRequest("POST", upstream.URL+"/a", nil)
+	require.NoError(t, err)
+	resp, err = rt.RoundTrip(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, Bypass, resp.Header.Get("X-Cache"))
+	resp, _ = get(t, rt, upstream.URL+"/a", nil)
+	assert.Equal(t, Miss, resp.Header.Get("X-Cache"))
+}
+
+func TestNotStored(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", r.URL.Query().Get("cc"))
+		if r.URL.Query().Get("vary") != "" {
+			w.Header().Set("Vary", "*")
+		}
+		w.Write([]byte("body"))
+	}))
+	defer upstream.Close()
+	c, _ := testCache()
+	rt := c.Transport(http.DefaultTransport)
+
+	// Test: responses a shared cache mustn't keep
+	for _, query := range []string{"cc=no-store", "cc=private,max-age=60", "cc=max-age=60&vary=1"} {
+		get(t, rt, upstream.URL+"/?"+query, nil)
+		resp, _ := get(t, rt, upstream.URL+"/?"+query, nil)
+		assert.Equal(t, Miss, resp.Header.Get("X-Cache"), query)
+	}
+
+	// Test: nor responses to requests with credentials, unless they say so
+	auth := http.Header{"Authorization": {"Bearer x"}}
+	get(t, rt, upstream.URL+"/?cc=max-age=60", auth)
+	resp, _ := get(t, rt, upstream.URL+"/?cc=max-age=60", auth)
+	assert.Equal(t, Miss, resp.Header.Get("X-Cache"))
+	get(t, rt, upstream.URL+"/?cc=public,max-age=60", auth)
+	resp, _ = get(t, rt, upstream.URL+"/?cc=public,max-age=60", auth)
+	assert.Equal(t, Hit, resp.Header.Get("X-Cache"))
+
+	// Test: only-if-cached with nothing stored
+	resp, _ = get(t, rt, upstream.URL+"/?cc=none", http.Header{"Cache-Control": {"only-if-cached"}})
+	assert.Equal(t, 504, resp.StatusCode)
+}
+
+func TestVary(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		w.Write([]byte("lang " + r.Header.Get("Accept-Language")))
+	}))
+	defer upstream.Close()
+	c, _ := testCache()
+	rt := c.Transport(http.DefaultTransport)
+	english := http.Header{"Accept-Language": {"en"}}
+	french := http.Header{"Accept-Language": {"fr"}}
+
+	// Test: each variant is stored and served to matching requests only
+	get(t, rt, upstream.URL, english)
+	get(t, rt, upstream.URL, french)
+	resp, body := get(t, rt, upstream.URL, english)
+	assert.Equal(t, Hit, resp.Header.Get("X-Cache"))
+	assert.Equal(t, "lang en", body)
+	resp, body = get(t, rt, upstream.URL, french)
+	assert.Equal(t, Hit, resp.Header.Get("X-Cache"))
+	assert.Equal(t, "lang fr", body)
+	resp, _ = get(t, rt, upstream.URL, http.Header{"Accept-Language": {"de"}})
+	assert.Equal(t, Miss, resp.Header.Get("X-Cache"))
+
+	// Test: a POST invalidates every variant, not just the one stored last
+	req, err := http.NewRequest("POST", upstream.URL, nil)
+	require.NoError(t, err)
+	resp, err = rt.RoundTrip(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	resp, _ = get(t, rt, upstream.URL, english)
+	assert.Equal(t, Miss, resp.Header.Get("X-Cache"))
+	resp, body = get(t, rt, upstream.URL, french)
+	assert.Equal(t, Miss, resp.Header.Get("X-Cache"))
+	assert.Equal(t, "lang fr", body)
+}
+
+func TestRevalidation(t *testing.T) {
+	var requests, notModified atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Cache-Control", "max-age=10")
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified.Add(1)
+			w.Header().Set("X-Refreshed", "yes")
+			w.WriteHeader(304)
+			return
+		}
+		w.Write([]byte("version one"))
+	}))
+	defer upstream.Close()
+	c, now := testCache()
+	rt := c.Transport(http.DefaultTransport)
+
+	get(t, rt, upstream.URL, nil)
+	*now = now.Add(time.Minute)
+
+	// Test: a stale entry with an ETag is checked, not fetched again
+	resp, body := get(t, rt, upstream.URL, nil)
+	assert.Equal(t, Revalidated, resp.Header.Get("X-Cache"))
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "version one", body)
+	assert.Equal(t, "yes", resp.Header.Get("X-Refreshed"))
+	assert.Equal(t, int32(1), notModified.Load())
+
+	// Test: and it's fresh again afterwards
+	resp, _ = get(t, rt, upstream.URL, nil)
+	assert.Equal(t, Hit, resp.Header.Get("X-Cache"))
+	assert.Equal(t, int32(2), requests.Load())
+
+	// Test: the client's own conditional is answered from the cache
+	resp, body = get(t, rt, upstream.URL, http.Header{"If-None-Match": {`W/"v1"`}})
+	assert.Equal(t, 304, resp.StatusCode)
+	assert.Empty(t, body)
+	assert.Equal(t, int32(2), requests.Load())
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	var version atomic.Int32
+	version.Store(1)
+	revalidated := make(chan struct{}, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=10, stale-while-revalidate=30")
+		w.Write([]byte("version " + strconv.Itoa(int(version.Load()))))
+		if version.Load() > 1 {
+			revalidated <- struct{}{}
+		}
+	}))
+	defer upstream.Close()
+	c, now := testCache()
+	rt := c.Transport(http.DefaultTransport)
+
+	get(t, rt, upstream.URL, nil)
+	version.Store(2)
+	*now = now.Add(20 * time.Second)
+
+	// Test: a little stale is served straight away while it's refreshed
+	resp, body := get(t, rt, upstream.URL, nil)
+	assert.Equal(t, Stale, resp.Header.Get("X-Cache"))
+	assert.Equal(t, "version 1", body)
+	select {
+	case <-revalidated:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stale entry wasn't revalidated")
+	}
+	require.Eventually(t, func() bool {
+		resp, body := get(t, rt, upstream.URL, nil)
+		return resp.Header.Get("X-Cache") == Hit && body == "version 2"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Test: too stale waits for upstream
+	*now = now.Add(time.Hour)
+	resp, _ = get(t, rt, upstream.URL, nil)
+	assert.Equal(t, Miss, resp.Header.Get("X-Cache"))
+}
+
+func TestMaxEntrySize(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write(make([]byte, 100))
+	}))
+	defer upstream.Close()
+	c, _ := testCache()
+	c.MaxEntrySize = 50
+	rt := c.Transport(http.DefaultTransport)
+
+	// Test: bodies over the limit pass through without being kept
+	get(t, rt, upstream.URL, nil)
+	resp, body := get(t, rt, upstream.URL, nil)
+	assert.Equal(t, Miss, resp.Header.Get("X-Cache"))
+	assert.Len(t, body, 100)
+}
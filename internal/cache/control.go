@@ -0,0 +1,180 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// directives are the Cache-Control directives of a message, by lowercase
+// name, with quotes taken off their values.
+type directives map[string]string
+
+// parseControl reads every Cache-Control field of h (RFC 9111 section
+// 5.2). A request with just the old "Pragma: no-cache" gets no-cache.
+func parseControl(h http.Header) directives {
+	d := directives{}
+	values := h.Values("Cache-Control")
+	if len(values) == 0 && hasToken(h.Get("Pragma"), "no-cache") {
+		d["no-cache"] = ""
+		return d
+	}
+
+	for _, part := range splitOutsideQuotes(strings.Join(values, ",")) {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if unquoted, err := strconv.Unquote(value); err == nil && strings.HasPrefix(value, `"`) {
+			value = unquoted
+		}
+		if _, seen := d[name]; !seen {
+			d[name] = value
+		}
+	}
+	return d
+}
+
+// splitOutsideQuotes splits s on commas, except inside quoted strings like
+// no-cache="Set-Cookie, Date".
+func splitOutsideQuotes(s string) []string {
+	var parts []string
+	quoted := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if quoted {
+				i++
+			}
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func hasToken(value string, token string) bool {
+	for _, part := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(part), token) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d directives) has(name string) bool {
+	_, ok := d[name]
+	return ok
+}
+
+// seconds returns the delta-seconds value of a directive. A value that
+// isn't a number counts as 0, so a broken max-age errs on the side of
+// stale.
+func (d directives) seconds(name string) (time.Duration, bool) {
+	value, ok := d[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, true
+	}
+	if n > int64(maxDuration/time.Second) {
+		return maxDuration, true
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+const maxDuration = time.Duration(1<<63 - 1)
+
+// heuristicStatus are the statuses a response can be cached with when it
+// doesn't say for how long (RFC 9110 section 15.1). 206 is left out since
+// partial content isn't stored.
+var heuristicStatus = map[int]bool{
+	200: true, 203: true, 204: true, 300: true, 301: true, 308: true,
+	404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+// maxHeuristicLifetime caps the freshness guessed from Last-Modified.
+const maxHeuristicLifetime = 24 * time.Hour
+
+// storable reports whether a shared cache may keep resp to req (RFC 9111
+// section 3).
+func storable(req *http.Request, resp *http.Response) bool {
+	if req.Method != "GET" {
+		return false
+	}
+	if resp.StatusCode < 200 || resp.StatusCode == 206 || resp.StatusCode == 304 {
+		return false
+	}
+	rc, sc := parseControl(req.Header), parseControl(resp.Header)
+	if rc.has("no-store") || sc.has("no-store") || sc.has("private") {
+		return false
+	}
+	if vary := resp.Header.Values("Vary"); hasToken(strings.Join(vary, ","), "*") {
+		return false
+	}
+	if req.Header.Get("Authorization") != "" && !sc.has("must-revalidate") && !sc.has("public") && !sc.has("s-maxage") {
+		return false
+	}
+	return sc.has("public") || sc.has("max-age") || sc.has("s-maxage") ||
+		resp.Header.Get("Expires") != "" || heuristicStatus[resp.StatusCode]
+}
+
+// freshnessLifetime is how long e is fresh for after it was generated
+// (RFC 9111 section 4.2.1).
+func (e *entry) freshnessLifetime() time.Duration {
+	sc := parseControl(e.Header)
+	if lifetime, ok := sc.seconds("s-maxage"); ok {
+		return lifetime
+	}
+	if lifetime, ok := sc.seconds("max-age"); ok {
+		return lifetime
+	}
+	if expires := e.Header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			// an invalid Expires means already expired
+			return 0
+		}
+		return t.Sub(e.date())
+	}
+
+	if !heuristicStatus[e.Status] && !sc.has("public") {
+		return 0
+	}
+	lastModified, err := http.ParseTime(e.Header.Get("Last-Modified"))
+	if err != nil {
+		return 0
+	}
+	// the usual heuristic: a tenth of how long it went unmodified
+	return min(e.date().Sub(lastModified)/10, maxHeuristicLifetime)
+}
+
+func (e *entry) date() time.Time {
+	if date, err := http.ParseTime(e.Header.Get("Date")); err == nil {
+		return date
+	}
+	return e.ResponseTime
+}
+
+// age is how old e is at now, counting time spent in other caches on
+// the way and in transit (RFC 9111 section 4.2.3).
+func (e *entry) age(now time.Time) time.Duration {
+	apparentAge := max(0, e.ResponseTime.Sub(e.date()))
+	var ageValue time.Duration
+	if seconds, err := strconv.ParseInt(e.Header.Get("Age"), 10, 64); err == nil && seconds > 0 {
+		ageValue = time.Duration(seconds) * time.Second
+	}
+	correctedAge := ageValue + e.ResponseTime.Sub(e.RequestTime)
+	return max(apparentAge, correctedAge) + now.Sub(e.ResponseTime)
+}
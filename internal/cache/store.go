@@ -0,0 +1,226 @@
+package cache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Store keeps cached responses, encoded, by key. Stores are bounded by the
+// bytes they hold and drop the least recently used entries to make room.
+type Store interface {
+	// Get returns the value saved for key, or ok == false if there is none.
+	Get(key string) (value []byte, ok bool, err error)
+	Set(key string, value []byte) error
+	Delete(key string) error
+}
+
+// lru tracks entry sizes in least recently used order. It isn't safe for
+// concurrent use; the stores lock around it.
+type lru struct {
+	maxBytes int64
+	size     int64
+	order    *list.List // of *lruItem, most recently used first
+	items    map[string]*list.Element
+}
+
+type lruItem struct {
+	key  string
+	size int64
+}
+
+func newLRU(maxBytes int64) *lru {
+	return &lru{maxBytes: maxBytes, order: list.New(), items: map[string]*list.Element{}}
+}
+
+func (l *lru) touch(key string) bool {
+	element, ok := l.items[key]
+	if ok {
+		l.order.MoveToFront(element)
+	}
+	return ok
+}
+
+// add records key as the most recently used and returns the keys evicted
+// to keep under maxBytes.
+func (l *lru) add(key string, size int64) []string {
+	l.remove(key)
+	if size > l.maxBytes {
+		// it would push out everything else and still not fit
+		return []string{key}
+	}
+	l.items[key] = l.order.PushFront(&lruItem{key: key, size: size})
+	l.size += size
+
+	var evicted []string
+	for l.size > l.maxBytes {
+		oldest := l.order.Back().Value.(*lruItem)
+		l.remove(oldest.key)
+		evicted = append(evicted, oldest.key)
+	}
+	return evicted
+}
+
+func (l *lru) remove(key string) {
+	element, ok := l.items[key]
+	if !ok {
+		return
+	}
+	l.size -= element.Value.(*lruItem).size
+	l.order.Remove(element)
+	delete(l.items, key)
+}
+
+// MemoryStore is a Store in memory, holding at most maxBytes of values.
+type MemoryStore struct {
+	mu     sync.Mutex
+	lru    *lru
+	values map[string][]byte
+}
+
+func NewMemoryStore(maxBytes int64) *MemoryStore {
+	return &MemoryStore{lru: newLRU(maxBytes), values: map[string][]byte{}}
+}
+
+func (s *MemoryStore) Get(key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.lru.touch(key) {
+		return nil, false, nil
+	}
+	return s.values[key], true, nil
+}
+
+func (s *MemoryStore) Set(key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+	for _, evicted := range s.lru.add(key, int64(len(value))) {
+		delete(s.values, evicted)
+	}
+	return nil
+}
+
+func (s *MemoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lru.remove(key)
+	delete(s.values, key)
+	return nil
+}
+
+// DiskStore is a Store of files in a directory, holding at most maxBytes
+// of values. Entries already in the directory are picked up, oldest
+// modified first out.
+type DiskStore struct {
+	dir string
+
+	mu  sync.Mutex
+	lru *lru
+}
+
+func NewDiskStore(dir string, maxBytes int64) (*DiskStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []fs.FileInfo
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || filepath.Ext(entry.Name()) != ".entry" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().Before(infos[j].ModTime())
+	})
+
+	s := &DiskStore{dir: dir, lru: newLRU(maxBytes)}
+	for _, info := range infos {
+		for _, evicted := range s.lru.add(info.Name(), info.Size()) {
+			os.Remove(filepath.Join(dir, evicted))
+		}
+	}
+	return s, nil
+}
+
+// fileName hashes key, which can hold any bytes, into a safe file name.
+// The LRU tracks file names too, so both agree on what's evicted.
+func fileName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:]) + ".entry"
+}
+
+func (s *DiskStore) Get(key string) ([]byte, bool, error) {
+	name := fileName(key)
+	s.mu.Lock()
+	known := s.lru.touch(name)
+	s.mu.Unlock()
+	if !known {
+		return nil, false, nil
+	}
+
+	value, err := os.ReadFile(filepath.Join(s.dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (s *DiskStore) Set(key string, value []byte) error {
+	name := fileName(key)
+	// write then rename, so a reader never sees half an entry
+	tmp, err := os.CreateTemp(s.dir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(value); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, name)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	for _, evicted := range s.lru.add(name, int64(len(value))) {
+		os.Remove(filepath.Join(s.dir, evicted))
+	}
+	return nil
+}
+
+func (s *DiskStore) Delete(key string) error {
+	name := fileName(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lru.remove(name)
+	err := os.Remove(filepath.Join(s.dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testStore(t *testing.T, s Store) {
+	// Test: values come back
+	require.NoError(t, s.Set("a", []byte("0123456789")))
+	require.NoError(t, s.Set("b", []byte("0123456789")))
+	value, ok, err := s.Get("a")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "0123456789", string(value))
+
+	// Test: going over the byte limit drops the least recently used, b
+	require.NoError(t, s.Set("c", []byte("0123456789")))
+	_, ok, _ = s.Get("b")
+	assert.False(t, ok)
+	_, ok, _ = s.Get("a")
+	assert.True(t, ok)
+	_, ok, _ = s.Get("c")
+	assert.True(t, ok)
+
+	// Test: a value bigger than the whole store isn't kept
+	require.NoError(t, s.Set("huge", make([]byte, 100)))
+	_, ok, _ = s.Get("huge")
+	assert.False(t, ok)
+
+	// Test: deleting
+	require.NoError(t, s.Delete("a"))
+	_, ok, _ = s.Get("a")
+	assert.False(t, ok)
+	require.NoError(t, s.Delete("missing"))
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore(25))
+}
+
+func TestDiskStore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewDiskStore(dir, 25)
+	require.NoError(t, err)
+	testStore(t, s)
+
+	// Test: entries survive a restart, and count towards the limit
+	files, err := filepath.Glob(filepath.Join(dir, "*.entry"))
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+	s, err = NewDiskStore(dir, 25)
+	require.NoError(t, err)
+	value, ok, err := s.Get("c")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "0123456789", string(value))
+
+	require.NoError(t, s.Set("d", []byte("0123456789")))
+	require.NoError(t, s.Set("e", []byte("0123456789")))
+	_, ok, _ = s.Get("c")
+	assert.False(t, ok)
+	_, err = os.Stat(filepath.Join(dir, fileName("c")))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,150 @@
+// Package capture records requests as JSON Lines, one Record per line, and
+// sends them again with a Replayer.
+package capture
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jsleep/httpfromtcp/internal/headers"
+	"github.com/jsleep/httpfromtcp/internal/request"
+)
+
+// Record is one captured request. A request that couldn't be parsed is
+// kept too, with only the times, Remote and Error set.
+type Record struct {
+	// Started is when reading the request began and Received when it had
+	// all arrived.
+	Started  time.Time `json:"started"`
+	Received time.Time `json:"received"`
+	Remote   string    `json:"remote"`
+
+	Method  string          `json:"method,omitempty"`
+	Target  string          `json:"target,omitempty"`
+	Version string          `json:"version,omitempty"`
+	Headers headers.Headers `json:"headers,omitempty"`
+	Body    []byte          `json:"body,omitempty"`
+
+	// Status is the response the request got when it was captured, if it
+	// was sent on anywhere. Replays are compared against it.
+	Status int `json:"status,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// FromRequest records req, read from remote between started and received.
+func FromRequest(req *request.Request, remote string, started time.Time, received time.Time) Record {
+	return Record{
+		Started:  started,
+		Received: received,
+		Remote:   remote,
+		Method:   req.RequestLine.Method,
+		Target:   req.RequestLine.RequestTarget,
+		Version:  req.RequestLine.HttpVersion,
+		Headers:  req.Headers,
+		Body:     req.Body,
+	}
+}
+
+// Replayable reports whether rec holds a request that can be sent again:
+// it was parsed, and isn't a CONNECT or "OPTIONS *", which aren't for a
+// particular URL.
+func (rec Record) Replayable() bool {
+	return rec.Error == "" && rec.Method != "" && rec.Method != "CONNECT" && rec.Target != "*"
+}
+
+// Request rebuilds rec as a request to the server at base, keeping the
+// path and query it was sent to, its headers and body. Any path in base
+// is put in front of the captured one. The Host header names base unless
+// keepHost asks for the captured one, e.g. for a server with virtual hosts
+// that knows the original name.
+func (rec Record) Request(base *url.URL, keepHost bool) (*request.Request, error) {
+	if !rec.Replayable() {
+		return nil, errors.New("can't replay " + rec.Method + " " + rec.Target)
+	}
+	target := rec.Target
+	if !strings.HasPrefix(target, "/") {
+		u, err := url.Parse(target)
+		if err != nil {
+			return nil, err
+		}
+		target = u.RequestURI()
+	}
+	req, err := request.NewRequest(rec.Method, base.Scheme+"://"+base.Host+strings.TrimSuffix(base.Path, "/")+target, rec.Body)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range rec.Headers {
+		if !keepHost && strings.EqualFold(name, "host") {
+			continue
+		}
+		req.Headers.Set(name, value)
+	}
+	return req, nil
+}
+
+// Writer appends Records to a JSON Lines stream. It's safe to use from
+// several goroutines at once.
+type Writer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+func (w *Writer) Write(rec Record) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err = w.w.Write(line)
+	return err
+}
+
+// ErrInvalidRecord is returned by Reader.Next for a line it can't decode.
+var ErrInvalidRecord = errors.New("invalid capture record")
+
+// Reader reads Records back from a JSON Lines stream.
+type Reader struct {
+	reader *bufio.Reader
+	line   int
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{reader: bufio.NewReader(r)}
+}
+
+// Next returns the next Record, or io.EOF once there are none left. A line
+// that isn't a valid Record is an ErrInvalidRecord naming its line number,
+// after which Next carries on with the following line. Blank lines are
+// skipped.
+func (r *Reader) Next() (Record, error) {
+	for {
+		line, err := r.reader.ReadBytes('\n')
+		if len(line) == 0 && err != nil {
+			return Record{}, err
+		}
+		r.line++
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return Record{}, fmt.Errorf("line %d: %w: %v", r.line, ErrInvalidRecord, err)
+		}
+		return rec, nil
+	}
+}
@@ -0,0 +1,107 @@
+package capture
+
+import (
+	"bytes"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jsleep/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteAndRead(t *testing.T) {
+	req, err := request.RequestFromReader(strings.NewReader("POST /items?id=7 HTTP/1.1\r\nHost: localhost:42069\r\nContent-Type: application/octet-stream\r\nContent-Length: 4\r\n\r\n\x00\x01\xfe\xff"))
+	require.NoError(t, err)
+	started := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	received := started.Add(3 * time.Millisecond)
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.Write(FromRequest(req, "127.0.0.1:5555", started, received)))
+	require.NoError(t, w.Write(Record{Started: started, Received: received, Remote: "127.0.0.1:5556", Error: "malformed request-line"}))
+
+	// Test: one JSON object per line
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	require.Len(t, lines, 2)
+	assert.Contains(t, lines[0], `"remote":"127.0.0.1:5555"`)
+	assert.Contains(t, lines[0], `"started":"2026-10-19T12:00:00Z"`)
+	assert.Contains(t, lines[1], `"error":"malformed request-line"`)
+	assert.NotContains(t, lines[1], `"method"`)
+
+	// Test: records come back the way they went in, binary bodies too
+	r := NewReader(&buf)
+	rec, err := r.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "POST", rec.Method)
+	assert.Equal(t, "/items?id=7", rec.Target)
+	assert.Equal(t, "1.1", rec.Version)
+	assert.Equal(t, "localhost:42069", rec.Headers.Get("Host"))
+	assert.Equal(t, []byte{0, 1, 0xfe, 0xff}, rec.Body)
+	assert.True(t, rec.Started.Equal(started))
+	assert.True(t, rec.Received.Equal(received))
+	assert.True(t, rec.Replayable())
+	rec, err = r.Next()
+	require.NoError(t, err)
+	assert.False(t, rec.Replayable())
+	_, err = r.Next()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestReaderSkipsBadLines(t *testing.T) {
+	r := NewReader(strings.NewReader("{\"method\":\"GET\",\"target\":\"/a\"}\n\nnot json\n{\"method\":\"GET\",\"target\":\"/b\"}"))
+
+	// Test: a bad line is reported with its number and reading carries on
+	rec, err := r.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "/a", rec.Target)
+	_, err = r.Next()
+	assert.ErrorIs(t, err, ErrInvalidRecord)
+	assert.ErrorContains(t, err, "line 3")
+	rec, err = r.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "/b", rec.Target)
+	_, err = r.Next()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestRecordRequest(t *testing.T) {
+	base, err := url.Parse("http://localhost:8080/v2/")
+	require.NoError(t, err)
+
+	// Test: origin-form targets go under the base URL's path
+	rec := Record{Method: "PUT", Target: "/items/7?force=1", Headers: map[string]string{"host": "example.com", "x-trace": "abc"}, Body: []byte("lamp")}
+	req, err := rec.Request(base, false)
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost:8080/v2/items/7?force=1", req.RequestLine.RequestTarget)
+	assert.Equal(t, "abc", req.Headers.Get("X-Trace"))
+	assert.Equal(t, "lamp", string(req.Body))
+
+	// Test: the Host header names the server replayed to
+	var wire bytes.Buffer
+	_, err = req.WriteTo(&wire)
+	require.NoError(t, err)
+	assert.Contains(t, wire.String(), "\r\nHost: localhost:8080\r\n")
+	assert.NotContains(t, wire.String(), "example.com")
+
+	// Test: unless the captured one is asked for
+	req, err = rec.Request(base, true)
+	require.NoError(t, err)
+	assert.Equal(t, "example.com", req.Headers.Get("Host"))
+
+	// Test: absolute-form targets keep only their path and query
+	rec = Record{Method: "GET", Target: "http://example.com/where?q=1"}
+	req, err = rec.Request(base, false)
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost:8080/v2/where?q=1", req.RequestLine.RequestTarget)
+
+	// Test: things that can't be sent again
+	for _, rec := range []Record{{Method: "CONNECT", Target: "example.com:443"}, {Method: "OPTIONS", Target: "*"}, {Error: "bad request"}} {
+		assert.False(t, rec.Replayable())
+		_, err = rec.Request(base, false)
+		assert.Error(t, err)
+	}
+}
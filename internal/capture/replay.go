@@ -0,0 +1,198 @@
+package capture
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/jsleep/httpfromtcp/internal/client"
+)
+
+// Replayer sends captured requests to Target and compares the status codes
+// that come back with the ones recorded, or with Baseline's.
+type Replayer struct {
+	Target *url.URL
+
+	// Baseline, when set, is sent every request too, and the status it
+	// answers with is the one Target's is compared against. It's how a
+	// capture without recorded statuses, or from another deployment, is
+	// diffed, e.g. the current release as Baseline and a candidate as
+	// Target.
+	Baseline *url.URL
+
+	// Concurrency is how many requests can be in flight at once, at least
+	// 1. Rate caps how many are started per second, 0 meaning as fast as
+	// Concurrency allows.
+	Concurrency int
+	Rate        float64
+
+	// KeepHost sends the captured Host header instead of Target's.
+	KeepHost bool
+
+	// Client sends the requests. When nil it's client.DefaultClient.
+	Client *client.Client
+}
+
+// Result is how one replayed request went. Expected is the status it's
+// compared against: the Baseline's, or else the recorded one, 0 when
+// there's neither. Err is set when no response came back, from Target or
+// Baseline, in which case Status is 0.
+type Result struct {
+	Record   Record
+	Expected int
+	Status   int
+	Err      error
+	Latency  time.Duration
+}
+
+// Changed reports whether the request got a different status than
+// expected. Results with nothing to compare against never change.
+func (r Result) Changed() bool {
+	return r.Err == nil && r.Expected != 0 && r.Status != r.Expected
+}
+
+// StatusChange is an expected status and the one a replay got instead.
+type StatusChange struct {
+	From int
+	To   int
+}
+
+// Summary counts the outcomes of a replay. Skipped records weren't
+// replayable, see Record.Replayable, and Unchecked ones had no status to
+// compare against.
+type Summary struct {
+	Sent      int
+	Skipped   int
+	Errors    int
+	Matched   int
+	Changed   int
+	Unchecked int
+	Changes   map[StatusChange]int
+	Statuses  map[int]int
+	Duration  time.Duration
+}
+
+// Run replays records in order, reporting each Result as it arrives, and
+// returns the totals once they're all done or ctx is. report is never
+// called concurrently and may be nil.
+func (rp *Replayer) Run(ctx context.Context, records []Record, report func(Result)) Summary {
+	summary := Summary{Changes: map[StatusChange]int{}, Statuses: map[int]int{}}
+	start := time.Now()
+
+	concurrency := rp.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	var ticks <-chan time.Time
+	if rp.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / rp.Rate))
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	jobs := make(chan Record)
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for rec := range jobs {
+				result := rp.send(ctx, rec)
+				mu.Lock()
+				summary.add(result)
+				if report != nil {
+					report(result)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	first := true
+dispatch:
+	for _, rec := range records {
+		if !rec.Replayable() {
+			mu.Lock()
+			summary.Skipped++
+			mu.Unlock()
+			continue
+		}
+		// the first request goes straight away, the rest wait their turn
+		if ticks != nil && !first {
+			select {
+			case <-ticks:
+			case <-ctx.Done():
+				break dispatch
+			}
+		}
+		first = false
+		select {
+		case jobs <- rec:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	summary.Duration = time.Since(start)
+	return summary
+}
+
+func (rp *Replayer) send(ctx context.Context, rec Record) Result {
+	result := Result{Record: rec, Expected: rec.Status}
+	if rp.Baseline != nil {
+		status, _, err := rp.do(ctx, rec, rp.Baseline)
+		if err != nil {
+			result.Err = errors.New("baseline: " + err.Error())
+			return result
+		}
+		result.Expected = status
+	}
+	result.Status, result.Latency, result.Err = rp.do(ctx, rec, rp.Target)
+	return result
+}
+
+// do sends rec to base and returns the response status and how long the
+// whole response took.
+func (rp *Replayer) do(ctx context.Context, rec Record, base *url.URL) (int, time.Duration, error) {
+	req, err := rec.Request(base, rp.KeepHost)
+	if err != nil {
+		return 0, 0, err
+	}
+	c := rp.Client
+	if c == nil {
+		c = client.DefaultClient
+	}
+
+	start := time.Now()
+	resp, err := c.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, 0, err
+	}
+	// read the body so the connection can be used again
+	_, err = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return int(resp.StatusLine.StatusCode), time.Since(start), err
+}
+
+func (s *Summary) add(r Result) {
+	s.Sent++
+	switch {
+	case r.Err != nil:
+		s.Errors++
+		return
+	case r.Changed():
+		s.Changed++
+		s.Changes[StatusChange{From: r.Expected, To: r.Status}]++
+	case r.Expected != 0:
+		s.Matched++
+	default:
+		s.Unchecked++
+	}
+	s.Statuses[r.Status]++
+}
@@ -0,0 +1,171 @@
+package capture
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jsleep/httpfromtcp/internal/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// statusServer answers each request with the status named by its "status"
+// query parameter, or 200.
+func statusServer(t *testing.T, handle func(r *http.Request)) *url.URL {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if handle != nil {
+			handle(r)
+		}
+		status, err := strconv.Atoi(r.URL.Query().Get("status"))
+		if err != nil {
+			status = 200
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(upstream.Close)
+	u, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+	return u
+}
+
+func TestReplay(t *testing.T) {
+	target := statusServer(t, nil)
+	records := []Record{
+		{Method: "GET", Target: "/", Status: 200},
+		{Method: "GET", Target: "/?status=404", Status: 200},
+		{Method: "POST", Target: "/?status=500", Status: 201, Body: []byte("x")},
+		{Method: "GET", Target: "/?status=404", Status: 200},
+		{Method: "GET", Target: "/no-status"},
+		{Error: "malformed request-line"},
+	}
+	c := &client.Client{}
+	defer c.CloseIdleConnections()
+	rp := &Replayer{Target: target, Concurrency: 2, Client: c}
+
+	var changed []string
+	summary := rp.Run(context.Background(), records, func(r Result) {
+		if r.Changed() {
+			changed = append(changed, r.Record.Target)
+		}
+	})
+
+	// Test: status changes are counted and reported, unparsed records skipped
+	assert.Equal(t, 5, summary.Sent)
+	assert.Equal(t, 1, summary.Skipped)
+	assert.Equal(t, 0, summary.Errors)
+	assert.Equal(t, 1, summary.Matched)
+	assert.Equal(t, 3, summary.Changed)
+	assert.Equal(t, 1, summary.Unchecked)
+	assert.Equal(t, map[StatusChange]int{{From: 200, To: 404}: 2, {From: 201, To: 500}: 1}, summary.Changes)
+	assert.Equal(t, map[int]int{200: 2, 404: 2, 500: 1}, summary.Statuses)
+	assert.ElementsMatch(t, []string{"/?status=404", "/?status=500", "/?status=404"}, changed)
+}
+
+func TestReplayBaseline(t *testing.T) {
+	baseline := statusServer(t, func(r *http.Request) {
+		if r.URL.Path == "/down" {
+			panic(http.ErrAbortHandler)
+		}
+	})
+	target := statusServer(t, func(r *http.Request) {
+		if r.URL.Path == "/broken" {
+			r.URL.RawQuery = "status=500"
+		}
+	})
+	// captured without an upstream, so no statuses
+	records := []Record{
+		{Method: "GET", Target: "/"},
+		{Method: "GET", Target: "/?status=404"},
+		{Method: "GET", Target: "/broken"},
+		{Method: "GET", Target: "/down"},
+	}
+	c := &client.Client{}
+	defer c.CloseIdleConnections()
+
+	// Test: without a baseline there's nothing to compare with
+	rp := &Replayer{Target: target, Client: c}
+	summary := rp.Run(context.Background(), records, nil)
+	assert.Equal(t, 0, summary.Matched)
+	assert.Equal(t, 0, summary.Changed)
+	assert.Equal(t, 4, summary.Unchecked)
+
+	// Test: with one, the target is compared with the baseline's statuses
+	rp.Baseline = baseline
+	results := map[string]Result{}
+	summary = rp.Run(context.Background(), records, func(r Result) {
+		results[r.Record.Target] = r
+	})
+	assert.Equal(t, 4, summary.Sent)
+	assert.Equal(t, 2, summary.Matched)
+	assert.Equal(t, 1, summary.Changed)
+	assert.Equal(t, 0, summary.Unchecked)
+	assert.Equal(t, map[StatusChange]int{{From: 200, To: 500}: 1}, summary.Changes)
+	assert.Equal(t, 404, results["/?status=404"].Expected)
+	assert.True(t, results["/broken"].Changed())
+
+	// Test: a baseline that doesn't answer is an error, not a change
+	assert.Equal(t, 1, summary.Errors)
+	assert.ErrorContains(t, results["/down"].Err, "baseline")
+	assert.False(t, results["/down"].Changed())
+}
+
+func TestReplayConcurrency(t *testing.T) {
+	var inFlight, most atomic.Int32
+	target := statusServer(t, func(r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			m := most.Load()
+			if n <= m || most.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	})
+	records := make([]Record, 8)
+	for i := range records {
+		records[i] = Record{Method: "GET", Target: "/"}
+	}
+
+	// Test: never more requests in flight than asked for
+	rp := &Replayer{Target: target, Concurrency: 3, Client: &client.Client{}}
+	summary := rp.Run(context.Background(), records, nil)
+	assert.Equal(t, 8, summary.Sent)
+	assert.LessOrEqual(t, most.Load(), int32(3))
+	assert.Greater(t, most.Load(), int32(1))
+}
+
+func TestReplayRate(t *testing.T) {
+	var mu sync.Mutex
+	var arrivals []time.Time
+	target := statusServer(t, func(r *http.Request) {
+		mu.Lock()
+		arrivals = append(arrivals, time.Now())
+		mu.Unlock()
+	})
+	records := make([]Record, 5)
+	for i := range records {
+		records[i] = Record{Method: "GET", Target: "/"}
+	}
+
+	// Test: 50 a second spaces 5 requests over about 80ms
+	rp := &Replayer{Target: target, Concurrency: 5, Rate: 50, Client: &client.Client{}}
+	summary := rp.Run(context.Background(), records, nil)
+	assert.Equal(t, 5, summary.Sent)
+	require.Len(t, arrivals, 5)
+	assert.GreaterOrEqual(t, arrivals[4].Sub(arrivals[0]), 70*time.Millisecond)
+
+	// Test: canceling stops the replay early
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rp.Rate = 1
+	summary = rp.Run(ctx, records, nil)
+	assert.Less(t, summary.Sent, 5)
+}
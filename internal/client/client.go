@@ -0,0 +1,382 @@
+// Package client sends requests over HTTP/1.1, written from
+// request.Request and read back with the response package, and keeps the
+// connections open for the next request to the same server.
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jsleep/httpfromtcp/internal/request"
+	"github.com/jsleep/httpfromtcp/internal/response"
+)
+
+const (
+	DefaultDialTimeout    = 30 * time.Second
+	DefaultIdleTimeout    = 90 * time.Second
+	DefaultMaxIdlePerHost = 2
+)
+
+// DefaultClient is the Client used by Do.
+var DefaultClient = &Client{}
+
+// Do sends req with DefaultClient.
+func Do(req *request.Request) (*response.Response, error) {
+	return DefaultClient.Do(req)
+}
+
+// Client sends requests and pools their connections. The zero value is
+// ready to use.
+type Client struct {
+	// TLSConfig is used for https URLs. The server name is filled in from
+	// the URL when it's empty.
+	TLSConfig *tls.Config
+
+	// DialTimeout bounds connecting, TLS handshake included. 0 means
+	// DefaultDialTimeout.
+	DialTimeout time.Duration
+
+	// MaxIdlePerHost is how many idle connections are kept for each
+	// server, 0 meaning DefaultMaxIdlePerHost and less than 0 none.
+	// IdleTimeout is how long they're kept, 0 meaning DefaultIdleTimeout.
+	MaxIdlePerHost int
+	IdleTimeout    time.Duration
+
+	mu   sync.Mutex
+	idle map[string][]*conn
+}
+
+// conn is a connection to a server and the reader responses on it are
+// parsed from, which may hold bytes already read.
+type conn struct {
+	net.Conn
+	reader    *bufio.Reader
+	idleSince time.Time
+	reused    bool
+}
+
+// aLongTimeAgo is a deadline that's already passed, to make blocked reads
+// and writes return when a request's context is done.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// Do sends req, which needs an absolute http or https URL as its target,
+// and returns the response once its headers have arrived. The caller must
+// close the response body; reading it to the end lets the connection be
+// used again. A 101 response's body is the connection itself, readable and
+// writable.
+//
+// Canceling the request's context aborts the request, body included.
+func (c *Client) Do(req *request.Request) (*response.Response, error) {
+	if req.RequestLine.TargetForm != request.AbsoluteForm {
+		return nil, errors.New("client needs an absolute URL, got: " + req.RequestLine.RequestTarget)
+	}
+	u, err := url.Parse(req.RequestLine.RequestTarget)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.New("unsupported URL scheme: " + u.Scheme)
+	}
+	key := u.Scheme + "://" + hostPort(u)
+
+	for {
+		pc, err := c.getConn(req.Context(), u, key)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.roundTrip(pc, req, key)
+		if err != nil && pc.reused && idempotent(req.RequestLine.Method) && req.Context().Err() == nil {
+			// the server probably closed the idle connection while we
+			// weren't looking, so try again on a new one
+			continue
+		}
+		return resp, err
+	}
+}
+
+func hostPort(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	if u.Scheme == "https" {
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
+
+// idempotent methods can be sent again without changing the outcome
+// (RFC 9110 section 9.2.2).
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+func (c *Client) roundTrip(pc *conn, req *request.Request, key string) (*response.Response, error) {
+	ctx := req.Context()
+	stop := context.AfterFunc(ctx, func() {
+		pc.SetDeadline(aLongTimeAgo)
+	})
+	fail := func(err error) (*response.Response, error) {
+		stop()
+		pc.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+
+	if err := writeRequest(pc, req); err != nil {
+		return fail(err)
+	}
+
+	var resp *response.Response
+	for {
+		var err error
+		resp, err = response.ReadResponse(pc.reader, req.RequestLine.Method)
+		if err != nil {
+			return fail(err)
+		}
+		// interim responses like 100 Continue come before the real one
+		code := resp.StatusLine.StatusCode
+		if code >= 200 || code == 101 {
+			break
+		}
+	}
+
+	if resp.StatusLine.StatusCode == 101 {
+		// the connection speaks another protocol now and belongs to the
+		// caller
+		stop()
+		resp.Body = &upgraded{conn: pc}
+		return resp, nil
+	}
+
+	body := &pooledBody{
+		body:     resp.Body,
+		client:   c,
+		conn:     pc,
+		key:      key,
+		ctx:      ctx,
+		reusable: !resp.Close && !hasToken(req.Headers.Get("connection"), "close"),
+		stop:     stop,
+	}
+	resp.Body = body
+	if resp.ContentLength == 0 {
+		body.finish(true)
+	}
+	return resp, nil
+}
+
+// writeRequest sends req in origin-form, "GET /where?q=1 HTTP/1.1", with a
+// Host header naming the server unless it has one already.
+func writeRequest(w io.Writer, req *request.Request) error {
+	u, err := url.Parse(req.RequestLine.RequestTarget)
+	if err != nil {
+		return err
+	}
+	out := *req
+	out.RequestLine.RequestTarget = u.RequestURI()
+	out.RequestLine.TargetForm = request.OriginForm
+	_, err = out.WriteTo(w)
+	return err
+}
+
+func hasToken(value string, token string) bool {
+	for _, part := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(part), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// pooledBody hands its connection back to the client once it's been read
+// to the end, or closes it if it's abandoned before then.
+type pooledBody struct {
+	body     io.ReadCloser
+	client   *Client
+	conn     *conn
+	key      string
+	ctx      context.Context
+	reusable bool
+	stop     func() bool
+	done     bool
+}
+
+func (b *pooledBody) Read(p []byte) (int, error) {
+	if b.done {
+		return 0, io.EOF
+	}
+	n, err := b.body.Read(p)
+	if err == io.EOF {
+		b.finish(true)
+	} else if err != nil {
+		b.finish(false)
+		if b.ctx.Err() != nil {
+			err = b.ctx.Err()
+		}
+	}
+	return n, err
+}
+
+func (b *pooledBody) Close() error {
+	if !b.done {
+		b.finish(false)
+	}
+	return b.body.Close()
+}
+
+func (b *pooledBody) finish(complete bool) {
+	b.done = true
+	// stop reports false once the context has set the deadline, and then
+	// the connection is no use to anyone
+	if b.stop() && complete && b.reusable {
+		b.client.putIdle(b.key, b.conn)
+		return
+	}
+	b.conn.Close()
+}
+
+// upgraded is a connection after a 101, reads starting with whatever was
+// buffered past the response.
+type upgraded struct {
+	conn *conn
+}
+
+func (u *upgraded) Read(p []byte) (int, error) {
+	return u.conn.reader.Read(p)
+}
+
+func (u *upgraded) Write(p []byte) (int, error) {
+	return u.conn.Write(p)
+}
+
+func (u *upgraded) Close() error {
+	return u.conn.Close()
+}
+
+// CloseWrite shuts down the writing side of the connection, for a proxy
+// passing on a half-close.
+func (u *upgraded) CloseWrite() error {
+	if cw, ok := u.conn.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return errors.New("connection can't be half-closed")
+}
+
+func (c *Client) dialTimeout() time.Duration {
+	if c.DialTimeout > 0 {
+		return c.DialTimeout
+	}
+	return DefaultDialTimeout
+}
+
+func (c *Client) idleTimeout() time.Duration {
+	if c.IdleTimeout > 0 {
+		return c.IdleTimeout
+	}
+	return DefaultIdleTimeout
+}
+
+func (c *Client) maxIdlePerHost() int {
+	if c.MaxIdlePerHost == 0 {
+		return DefaultMaxIdlePerHost
+	}
+	return c.MaxIdlePerHost
+}
+
+func (c *Client) getConn(ctx context.Context, u *url.URL, key string) (*conn, error) {
+	if pc := c.getIdle(key); pc != nil {
+		return pc, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.dialTimeout())
+	defer cancel()
+	var dialer net.Dialer
+	raw, err := dialer.DialContext(ctx, "tcp", hostPort(u))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "https" {
+		return &conn{Conn: raw, reader: bufio.NewReader(raw)}, nil
+	}
+
+	config := &tls.Config{}
+	if c.TLSConfig != nil {
+		config = c.TLSConfig.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName = u.Hostname()
+	}
+	tlsConn := tls.Client(raw, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		raw.Close()
+		return nil, err
+	}
+	return &conn{Conn: tlsConn, reader: bufio.NewReader(tlsConn)}, nil
+}
+
+// getIdle takes the most recently used idle connection to key, dropping
+// any that have been idle too long.
+func (c *Client) getIdle(key string) *conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	conns := c.idle[key]
+	for len(conns) > 0 {
+		pc := conns[len(conns)-1]
+		conns = conns[:len(conns)-1]
+		if time.Since(pc.idleSince) > c.idleTimeout() {
+			pc.Close()
+			continue
+		}
+		c.idle[key] = conns
+		pc.reused = true
+		return pc
+	}
+	delete(c.idle, key)
+	return nil
+}
+
+func (c *Client) putIdle(key string, pc *conn) {
+	if pc.reader.Buffered() > 0 {
+		// the server sent more than the response, which leaves the
+		// connection in no state for another
+		pc.Close()
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.idle[key]) >= c.maxIdlePerHost() {
+		pc.Close()
+		return
+	}
+	if c.idle == nil {
+		c.idle = map[string][]*conn{}
+	}
+	pc.idleSince = time.Now()
+	c.idle[key] = append(c.idle[key], pc)
+}
+
+// CloseIdleConnections closes every pooled connection that isn't in use.
+func (c *Client) CloseIdleConnections() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, conns := range c.idle {
+		for _, pc := range conns {
+			pc.Close()
+		}
+	}
+	c.idle = nil
+}
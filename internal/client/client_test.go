@@ -0,0 +1,180 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jsleep/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingServer is an upstream that records how many connections it's
+// been sent requests on.
+func countingServer(t *testing.T, handler http.HandlerFunc) (*httptest.Server, func() int) {
+	var mu sync.Mutex
+	conns := map[string]bool{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		conns[r.RemoteAddr] = true
+		mu.Unlock()
+		handler(w, r)
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(conns)
+	}
+}
+
+func TestDo(t *testing.T) {
+	upstream, _ := countingServer(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Method", r.Method)
+		w.Header().Set("X-Agent", r.Header.Get("User-Agent"))
+		w.WriteHeader(201)
+		w.Write([]byte(r.URL.RequestURI() + " " + string(body)))
+	})
+	c := &Client{}
+	defer c.CloseIdleConnections()
+
+	req, err := request.NewRequest("POST", upstream.URL+"/items?id=7", []byte("lamp"))
+	require.NoError(t, err)
+	req.Headers.Set("User-Agent", "httpfromtcp")
+	resp, err := c.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	// Test: the request arrives whole and the response comes back
+	assert.Equal(t, 201, int(resp.StatusLine.StatusCode))
+	assert.Equal(t, "POST", resp.Headers.Get("X-Method"))
+	assert.Equal(t, "httpfromtcp", resp.Headers.Get("X-Agent"))
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "/items?id=7 lamp", string(body))
+
+	// Test: only absolute http URLs
+	_, err = request.NewRequest("GET", "/relative", nil)
+	require.Error(t, err)
+	req, err = request.NewRequest("GET", "ftp://example.com/file", nil)
+	require.NoError(t, err)
+	_, err = c.Do(req)
+	require.Error(t, err)
+}
+
+func TestConnectionReuse(t *testing.T) {
+	upstream, connections := countingServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	c := &Client{}
+	defer c.CloseIdleConnections()
+
+	// Test: requests one after another share a connection once bodies are read
+	for range 3 {
+		req, err := request.NewRequest("GET", upstream.URL, nil)
+		require.NoError(t, err)
+		resp, err := c.Do(req)
+		require.NoError(t, err)
+		io.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	assert.Equal(t, 1, connections())
+
+	// Test: a body closed early takes its connection with it
+	req, err := request.NewRequest("GET", upstream.URL, nil)
+	require.NoError(t, err)
+	resp, err := c.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	req, err = request.NewRequest("GET", upstream.URL, nil)
+	require.NoError(t, err)
+	resp, err = c.Do(req)
+	require.NoError(t, err)
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, 2, connections())
+
+	// Test: a server closing an idle connection is retried on a new one
+	upstream.CloseClientConnections()
+	req, err = request.NewRequest("GET", upstream.URL, nil)
+	require.NoError(t, err)
+	resp, err = c.Do(req)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "ok", string(body))
+}
+
+func TestCancel(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	upstream, _ := countingServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	})
+	c := &Client{}
+	defer c.CloseIdleConnections()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := request.NewRequest("GET", upstream.URL, nil)
+	require.NoError(t, err)
+	resp, err := c.Do(req.WithContext(ctx))
+	require.NoError(t, err)
+
+	// Test: canceling the context ends a body that's still arriving
+	time.AfterFunc(10*time.Millisecond, cancel)
+	_, err = io.ReadAll(resp.Body)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestTransport(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "a", Value: "1"})
+		http.SetCookie(w, &http.Cookie{Name: "b", Value: "2"})
+		w.Header().Set("Trailer", "X-Checksum")
+		w.Write([]byte("via net/http " + r.Host))
+		w.Header().Set("X-Checksum", "abc123")
+	}))
+	defer upstream.Close()
+	httpClient := &http.Client{Transport: &Transport{Client: &Client{}}}
+
+	req, err := http.NewRequest("GET", upstream.URL, nil)
+	require.NoError(t, err)
+	req.Host = "example.com"
+	resp, err := httpClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	// Test: net/http code sees the usual response, cookies and trailers
+	assert.Equal(t, "200 OK", resp.Status)
+	assert.Len(t, resp.Cookies(), 2)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "via net/http example.com", string(body))
+	assert.Equal(t, "abc123", resp.Trailer.Get("X-Checksum"))
+}
+
+func TestDialFailure(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	ln.Close()
+
+	// Test: nothing listening
+	req, err := request.NewRequest("GET", "http://"+addr+"/", nil)
+	require.NoError(t, err)
+	_, err = (&Client{}).Do(req)
+	require.Error(t, err)
+}
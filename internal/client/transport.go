@@ -0,0 +1,118 @@
+package client
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jsleep/httpfromtcp/internal/request"
+	"github.com/jsleep/httpfromtcp/internal/response"
+)
+
+// Transport is an http.RoundTripper that sends requests with a Client,
+// for code written against net/http types, like the proxy.
+type Transport struct {
+	// Client sends the requests. nil means DefaultClient.
+	Client *Client
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	out, err := request.NewRequest(req.Method, req.URL.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range req.Header {
+		separator := ", "
+		if name == "Cookie" {
+			separator = "; "
+		}
+		out.Headers.Set(name, strings.Join(values, separator))
+	}
+	if req.Host != "" {
+		out.Headers.Set("Host", req.Host)
+	}
+	out = out.WithContext(req.Context())
+
+	c := t.Client
+	if c == nil {
+		c = DefaultClient
+	}
+	resp, err := c.Do(out)
+	if err != nil {
+		return nil, err
+	}
+	return httpResponse(req, resp), nil
+}
+
+// httpResponse converts resp to its net/http equivalent. Trailers are
+// announced up front from the Trailer header and filled in when the body
+// has been read, as net/http does.
+func httpResponse(req *http.Request, resp *response.Response) *http.Response {
+	code := int(resp.StatusLine.StatusCode)
+	out := &http.Response{
+		Status:        strconv.Itoa(code) + " " + resp.StatusLine.ReasonPhrase,
+		StatusCode:    code,
+		Proto:         "HTTP/" + resp.StatusLine.HttpVersion,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{},
+		Body:          resp.Body,
+		ContentLength: resp.ContentLength,
+		Close:         resp.Close,
+		Request:       req,
+	}
+	if resp.StatusLine.HttpVersion == "1.0" {
+		out.ProtoMinor = 0
+	}
+
+	for name, value := range resp.Headers {
+		if strings.EqualFold(name, "set-cookie") {
+			continue
+		}
+		out.Header[http.CanonicalHeaderKey(name)] = []string{value}
+	}
+	for _, value := range resp.SetCookies {
+		out.Header.Add("Set-Cookie", value)
+	}
+
+	if trailer := resp.Headers.Get("trailer"); trailer != "" {
+		out.Trailer = http.Header{}
+		for _, name := range strings.Split(trailer, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				out.Trailer[http.CanonicalHeaderKey(name)] = nil
+			}
+		}
+		if code != 101 {
+			out.Body = &trailerBody{ReadCloser: resp.Body, from: resp, to: out}
+		}
+	}
+	return out
+}
+
+// trailerBody copies the trailers over once the body has been read.
+type trailerBody struct {
+	io.ReadCloser
+	from *response.Response
+	to   *http.Response
+}
+
+func (b *trailerBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		for name, value := range b.from.Trailers {
+			b.to.Trailer[http.CanonicalHeaderKey(name)] = []string{value}
+		}
+	}
+	return n, err
+}
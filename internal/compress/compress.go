@@ -0,0 +1,205 @@
+package compress
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/jsleep/httpfromtcp/internal/headers"
+	"github.com/jsleep/httpfromtcp/internal/request"
+	"github.com/jsleep/httpfromtcp/internal/response"
+	"github.com/jsleep/httpfromtcp/internal/server"
+)
+
+// encodings we can produce, in order of preference when the client rates
+// them equally
+var supportedEncodings = []string{"gzip", "deflate"}
+
+// Compressor compresses response bodies for clients that accept it.
+type Compressor struct {
+	// MinSize is the smallest Content-Length worth compressing. Bodies of
+	// unknown length (chunked) are always compressed.
+	MinSize int
+	// Level is a compress/flate level, e.g. flate.BestSpeed.
+	Level int
+}
+
+func New() *Compressor {
+	return &Compressor{MinSize: 1024, Level: flate.DefaultCompression}
+}
+
+// Middleware compresses next's responses with the default settings.
+func Middleware(next server.Handler) server.Handler {
+	return New().Middleware(next)
+}
+
+// Middleware picks an encoding from the request's Accept-Encoding and, if
+// the response turns out to be worth compressing, rewrites its headers and
+// streams the body through a compressor as chunks.
+func (c *Compressor) Middleware(next server.Handler) server.Handler {
+	return func(w response.Writer, req *request.Request) *server.HandlerError {
+		encoding := Negotiate(req.Headers.Get("accept-encoding"))
+
+		var filter *compressFilter
+		w.OnWriteHeaders(func(w *response.Writer, h headers.Headers) {
+			addVary(h, "Accept-Encoding")
+			if encoding == "" || !c.shouldCompress(w.Status(), h) {
+				return
+			}
+
+			// a HEAD response gets the same headers but there is no body
+			// to compress
+			if !w.BodyDiscarded() {
+				f, err := newCompressFilter(encoding, c.Level, w.Writer)
+				if err != nil {
+					return
+				}
+				filter = f
+				w.SetBodyFilter(filter)
+			}
+
+			h.Set("Content-Encoding", encoding)
+			h.Delete("Content-Length")
+			h.Set("Transfer-Encoding", "chunked")
+			// the compressed bytes differ from what a strong ETag promised
+			if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+				h.Set("ETag", "W/"+etag)
+			}
+		})
+
+		handlerErr := next(w, req)
+
+		// handlers that wrote a plain body never call WriteChunkedBodyDone,
+		// so end the chunked body for them
+		if filter != nil && !filter.closed {
+			filter.Close()
+			w.Writer.Write([]byte("0\r\n\r\n"))
+		}
+		return handlerErr
+	}
+}
+
+// Negotiate picks the best encoding we support from an Accept-Encoding
+// header, or "" to send the body as is.
+func Negotiate(acceptEncoding string) string {
+	return request.NegotiateToken(acceptEncoding, supportedEncodings)
+}
+
+// incompressibleTypes are already compressed, so compressing them again
+// only costs CPU.
+var incompressibleTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/woff",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/zstd",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+	"application/x-bzip2",
+}
+
+func (c *Compressor) shouldCompress(status response.StatusCode, h headers.Headers) bool {
+	if status < 200 || status == 204 || status == 304 {
+		return false
+	}
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+
+	contentType := strings.ToLower(h.Get("Content-Type"))
+	if contentType != "image/svg+xml" {
+		for _, prefix := range incompressibleTypes {
+			if strings.HasPrefix(contentType, prefix) {
+				return false
+			}
+		}
+	}
+
+	if length := h.Get("Content-Length"); length != "" {
+		n, err := strconv.Atoi(length)
+		if err != nil || n < c.MinSize {
+			return false
+		}
+	}
+	return true
+}
+
+func addVary(h headers.Headers, field string) {
+	vary := h.Get("Vary")
+	for _, existing := range strings.Split(vary, ",") {
+		existing = strings.TrimSpace(existing)
+		if existing == "*" || strings.EqualFold(existing, field) {
+			return
+		}
+	}
+	if vary == "" {
+		h.Set("Vary", field)
+	} else {
+		h.Set("Vary", vary+", "+field)
+	}
+}
+
+type flushWriteCloser interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// compressFilter compresses body bytes and sends the output as chunks.
+type compressFilter struct {
+	encoder flushWriteCloser
+	closed  bool
+}
+
+func newCompressFilter(encoding string, level int, conn io.Writer) (*compressFilter, error) {
+	chunks := response.ChunkedWriter{W: &response.Writer{Writer: conn}}
+
+	var encoder flushWriteCloser
+	var err error
+	switch encoding {
+	case "gzip":
+		encoder, err = gzip.NewWriterLevel(chunks, level)
+	case "deflate":
+		// HTTP's "deflate" is the zlib format, not raw deflate
+		encoder, err = zlib.NewWriterLevel(chunks, level)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &compressFilter{encoder: encoder}, nil
+}
+
+func (f *compressFilter) Write(p []byte) (int, error) {
+	return f.encoder.Write(p)
+}
+
+// Flush sends whatever has been compressed so far as a chunk, for
+// streaming responses that can't wait for the compressor's buffer to fill.
+func (f *compressFilter) Flush() error {
+	return f.encoder.Flush()
+}
+
+func (f *compressFilter) Close() error {
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	return f.encoder.Close()
+}
+
+// DecodeRequests decodes gzip and deflate request bodies before next sees
+// them, answering 415 for encodings it doesn't know and 413 for bodies that
+// inflate past maxSize.
+func DecodeRequests(maxSize int64, next server.Handler) server.Handler {
+	return func(w response.Writer, req *request.Request) *server.HandlerError {
+		if err := req.DecodeContentEncoding(maxSize); err != nil {
+			return server.NewHandlerError(err)
+		}
+		return next(w, req)
+	}
+}
@@ -0,0 +1,144 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jsleep/httpfromtcp/internal/headers"
+	"github.com/jsleep/httpfromtcp/internal/request"
+	"github.com/jsleep/httpfromtcp/internal/response"
+	"github.com/jsleep/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiate(t *testing.T) {
+	assert.Equal(t, "", Negotiate(""))
+	assert.Equal(t, "gzip", Negotiate("gzip, deflate, br"))
+	assert.Equal(t, "deflate", Negotiate("gzip;q=0.5, deflate"))
+	assert.Equal(t, "deflate", Negotiate("gzip;q=0, *"))
+	assert.Equal(t, "gzip", Negotiate("*;q=0.1"))
+	assert.Equal(t, "", Negotiate("br, identity"))
+	assert.Equal(t, "", Negotiate("gzip;q=0"))
+}
+
+// dechunk decodes a chunked body and returns the data and the trailer
+// section.
+func dechunk(t *testing.T, body string) ([]byte, string) {
+	var data []byte
+	for {
+		sizeLine, rest, found := strings.Cut(body, "\r\n")
+		require.True(t, found)
+		size, err := strconv.ParseInt(sizeLine, 16, 64)
+		require.NoError(t, err)
+		if size == 0 {
+			return data, rest
+		}
+		data = append(data, rest[:size]...)
+		body = rest[size+2:]
+	}
+}
+
+func run(t *testing.T, acceptEncoding string, handler server.Handler) (string, string) {
+	raw := "GET / HTTP/1.1\r\n"
+	if acceptEncoding != "" {
+		raw += "Accept-Encoding: " + acceptEncoding + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.Nil(t, Middleware(handler)(response.Writer{Writer: &buf}, req))
+	head, body, found := strings.Cut(buf.String(), "\r\n\r\n")
+	require.True(t, found)
+	return head, body
+}
+
+var page = strings.Repeat("<p>hello compression</p>\n", 100)
+
+func pageHandler(body string, contentType string) server.Handler {
+	return func(w response.Writer, req *request.Request) *server.HandlerError {
+		h := response.GetDefaultHeaders(len(body))
+		h["Content-Type"] = contentType
+		h["ETag"] = `"abc"`
+		w.WriteStatusLine(200)
+		w.WriteHeaders(h)
+		w.WriteBody([]byte(body))
+		return nil
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	// Test: gzip with a Content-Length body switches to chunked
+	head, body := run(t, "gzip", pageHandler(page, "text/html"))
+	assert.Contains(t, head, "Content-Encoding: gzip")
+	assert.Contains(t, head, "Transfer-Encoding: chunked")
+	assert.Contains(t, head, "Vary: Accept-Encoding")
+	assert.Contains(t, head, `ETag: W/"abc"`)
+	assert.NotContains(t, head, "Content-Length")
+	compressed, trailers := dechunk(t, body)
+	assert.Equal(t, "\r\n", trailers)
+	assert.Less(t, len(compressed), len(page))
+	gz, err := gzip.NewReader(bytes.NewReader(compressed))
+	require.NoError(t, err)
+	plain, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Equal(t, page, string(plain))
+
+	// Test: deflate uses the zlib format
+	head, body = run(t, "deflate", pageHandler(page, "text/html"))
+	assert.Contains(t, head, "Content-Encoding: deflate")
+	compressed, _ = dechunk(t, body)
+	zr, err := zlib.NewReader(bytes.NewReader(compressed))
+	require.NoError(t, err)
+	plain, err = io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, page, string(plain))
+
+	// Test: no Accept-Encoding, tiny bodies and compressed types pass through
+	for _, tc := range []struct {
+		accept  string
+		handler server.Handler
+	}{
+		{"", pageHandler(page, "text/html")},
+		{"gzip", pageHandler("tiny", "text/plain")},
+		{"gzip", pageHandler(page, "image/png")},
+	} {
+		head, body = run(t, tc.accept, tc.handler)
+		assert.NotContains(t, head, "Content-Encoding")
+		assert.Contains(t, head, "Content-Length")
+		assert.Contains(t, head, "Vary: Accept-Encoding")
+		assert.NotEmpty(t, body)
+	}
+}
+
+func TestMiddlewareChunkedHandler(t *testing.T) {
+	// Test: a handler streaming chunks with trailers, like proxyHandler
+	handler := func(w response.Writer, req *request.Request) *server.HandlerError {
+		h := response.GetDefaultHeaders(0)
+		delete(h, "Content-Length")
+		h["Transfer-Encoding"] = "chunked"
+		h["Trailer"] = "X-Content-Length"
+		w.WriteStatusLine(200)
+		w.WriteHeaders(h)
+		w.WriteChunkedBody([]byte(page[:1000]))
+		w.WriteChunkedBody([]byte(page[1000:]))
+		w.WriteChunkedBodyDone()
+		w.WriteTrailers(headers.Headers{"X-Content-Length": strconv.Itoa(len(page))})
+		return nil
+	}
+	head, body := run(t, "gzip", handler)
+	assert.Contains(t, head, "Content-Encoding: gzip")
+	compressed, trailers := dechunk(t, body)
+	assert.Equal(t, "X-Content-Length: "+strconv.Itoa(len(page))+"\r\n\r\n", trailers)
+	gz, err := gzip.NewReader(bytes.NewReader(compressed))
+	require.NoError(t, err)
+	plain, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Equal(t, page, string(plain))
+}
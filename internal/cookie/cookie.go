@@ -0,0 +1,167 @@
+package cookie
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type SameSite int
+
+const (
+	SameSiteDefault SameSite = iota
+	SameSiteLax
+	SameSiteStrict
+	SameSiteNone
+)
+
+// Cookie is a single cookie as sent in a Set-Cookie response header.
+type Cookie struct {
+	Name  string
+	Value string
+
+	Path    string
+	Domain  string
+	Expires time.Time
+	// MaxAge > 0 sets Max-Age in seconds, MaxAge < 0 deletes the cookie
+	// with Max-Age=0, and MaxAge == 0 leaves the attribute out.
+	MaxAge      int
+	Secure      bool
+	HttpOnly    bool
+	SameSite    SameSite
+	Partitioned bool
+}
+
+const expiresFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// tchar from RFC 9110, the set of characters allowed in a cookie name
+const tokenChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!#$%&'*+-.^_`|~"
+
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, char := range name {
+		if !strings.ContainsRune(tokenChars, char) {
+			return false
+		}
+	}
+	return true
+}
+
+// cookie-octet from RFC 6265: no CTLs, whitespace, DQUOTE, comma,
+// semicolon or backslash
+func validValueByte(b byte) bool {
+	return b == 0x21 ||
+		(b >= 0x23 && b <= 0x2B) ||
+		(b >= 0x2D && b <= 0x3A) ||
+		(b >= 0x3C && b <= 0x5B) ||
+		(b >= 0x5D && b <= 0x7E)
+}
+
+func validValue(value string) bool {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+	for i := 0; i < len(value); i++ {
+		if !validValueByte(value[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// validAttribute checks path and domain values, which may contain any
+// character other than CTLs and ';'
+func validAttribute(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if value[i] < 0x20 || value[i] == 0x7F || value[i] == ';' {
+			return false
+		}
+	}
+	return true
+}
+
+// Valid reports whether the cookie can be written to a Set-Cookie header.
+func (c *Cookie) Valid() error {
+	if !validName(c.Name) {
+		return errors.New("invalid cookie name: " + c.Name)
+	}
+	if !validValue(c.Value) {
+		return errors.New("invalid cookie value for " + c.Name + ": " + c.Value)
+	}
+	if !validAttribute(c.Path) {
+		return errors.New("invalid cookie path: " + c.Path)
+	}
+	if !validAttribute(c.Domain) {
+		return errors.New("invalid cookie domain: " + c.Domain)
+	}
+	if c.Partitioned && !c.Secure {
+		return errors.New("partitioned cookie " + c.Name + " must be secure")
+	}
+	if c.SameSite == SameSiteNone && !c.Secure {
+		return errors.New("SameSite=None cookie " + c.Name + " must be secure")
+	}
+	return nil
+}
+
+// String serializes the cookie for use as a Set-Cookie header value. It
+// doesn't validate the cookie, call Valid first.
+func (c *Cookie) String() string {
+	var b strings.Builder
+	b.WriteString(c.Name + "=" + c.Value)
+	if c.Path != "" {
+		b.WriteString("; Path=" + c.Path)
+	}
+	if c.Domain != "" {
+		b.WriteString("; Domain=" + strings.TrimPrefix(c.Domain, "."))
+	}
+	if !c.Expires.IsZero() {
+		b.WriteString("; Expires=" + c.Expires.UTC().Format(expiresFormat))
+	}
+	if c.MaxAge > 0 {
+		b.WriteString("; Max-Age=" + strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		b.WriteString("; Max-Age=0")
+	}
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	switch c.SameSite {
+	case SameSiteLax:
+		b.WriteString("; SameSite=Lax")
+	case SameSiteStrict:
+		b.WriteString("; SameSite=Strict")
+	case SameSiteNone:
+		b.WriteString("; SameSite=None")
+	}
+	if c.Partitioned {
+		b.WriteString("; Partitioned")
+	}
+	return b.String()
+}
+
+// Parse parses the value of a request Cookie header into a name to value
+// map. Malformed pairs are skipped and the first value for a name wins.
+// Surrounding double quotes are stripped from values.
+func Parse(header string) map[string]string {
+	cookies := map[string]string{}
+	for _, pair := range strings.Split(header, ";") {
+		name, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found || !validName(name) || !validValue(value) {
+			continue
+		}
+		if _, exists := cookies[name]; exists {
+			continue
+		}
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+		}
+		cookies[name] = value
+	}
+	return cookies
+}
@@ -0,0 +1,54 @@
+package cookie
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestString(t *testing.T) {
+	// Test: all attributes
+	c := &Cookie{
+		Name:        "session",
+		Value:       "abc123",
+		Path:        "/",
+		Domain:      ".example.com",
+		Expires:     time.Date(2025, time.June, 1, 12, 0, 0, 0, time.UTC),
+		MaxAge:      3600,
+		Secure:      true,
+		HttpOnly:    true,
+		SameSite:    SameSiteNone,
+		Partitioned: true,
+	}
+	require.NoError(t, c.Valid())
+	assert.Equal(t, "session=abc123; Path=/; Domain=example.com; Expires=Sun, 01 Jun 2025 12:00:00 GMT; Max-Age=3600; Secure; HttpOnly; SameSite=None; Partitioned", c.String())
+
+	// Test: deleting a cookie
+	c = &Cookie{Name: "session", MaxAge: -1}
+	require.NoError(t, c.Valid())
+	assert.Equal(t, "session=; Max-Age=0", c.String())
+
+	// Test: invalid name and value
+	require.Error(t, (&Cookie{Name: "bad name", Value: "x"}).Valid())
+	require.Error(t, (&Cookie{Name: "name", Value: "a;b"}).Valid())
+	require.Error(t, (&Cookie{Name: "name", Value: "a b"}).Valid())
+
+	// Test: Partitioned and SameSite=None require Secure
+	require.Error(t, (&Cookie{Name: "name", Partitioned: true}).Valid())
+	require.Error(t, (&Cookie{Name: "name", SameSite: SameSiteNone}).Valid())
+}
+
+func TestParse(t *testing.T) {
+	// Test: several cookies with a quoted value
+	cookies := Parse(`a=1; b="two";c=3`)
+	assert.Equal(t, map[string]string{"a": "1", "b": "two", "c": "3"}, cookies)
+
+	// Test: first value wins and malformed pairs are skipped
+	cookies = Parse("a=1; a=2; junk; bad name=x; d=ok")
+	assert.Equal(t, map[string]string{"a": "1", "d": "ok"}, cookies)
+
+	// Test: empty header
+	assert.Empty(t, Parse(""))
+}
@@ -2,6 +2,7 @@ package headers
 
 import (
 	"errors"
+	"net/textproto"
 	"strings"
 )
 
@@ -29,7 +30,58 @@ func containsOnlyValidCharacters(s string) bool {
 
 func (h Headers) Get(key string) string {
 	// Convert key to lowercase to ensure case-insensitive access
-	return h[strings.ToLower(key)]
+	if value, ok := h[strings.ToLower(key)]; ok {
+		return value
+	}
+	// Response headers are usually built with canonical keys like
+	// "Content-Type", so fall back to a case-insensitive scan
+	for k, value := range h {
+		if strings.EqualFold(k, key) {
+			return value
+		}
+	}
+	return ""
+}
+
+// canonicalExceptions are header names whose usual spelling isn't plain
+// title case.
+var canonicalExceptions = map[string]string{
+	"etag":                     "ETag",
+	"te":                       "TE",
+	"www-authenticate":         "WWW-Authenticate",
+	"content-md5":              "Content-MD5",
+	"dnt":                      "DNT",
+	"x-xss-protection":         "X-XSS-Protection",
+	"sec-websocket-key":        "Sec-WebSocket-Key",
+	"sec-websocket-accept":     "Sec-WebSocket-Accept",
+	"sec-websocket-version":    "Sec-WebSocket-Version",
+	"sec-websocket-protocol":   "Sec-WebSocket-Protocol",
+	"sec-websocket-extensions": "Sec-WebSocket-Extensions",
+}
+
+// Canonical returns key in the form it's conventionally written on the
+// wire, e.g. "content-type" becomes "Content-Type" and "etag" "ETag".
+func Canonical(key string) string {
+	lower := strings.ToLower(key)
+	if canonical, ok := canonicalExceptions[lower]; ok {
+		return canonical
+	}
+	return textproto.CanonicalMIMEHeaderKey(lower)
+}
+
+// Set replaces any existing value for key, whatever its casing, with value.
+func (h Headers) Set(key, value string) {
+	h.Delete(key)
+	h[key] = value
+}
+
+// Delete removes key, whatever its casing.
+func (h Headers) Delete(key string) {
+	for k := range h {
+		if strings.EqualFold(k, key) {
+			delete(h, k)
+		}
+	}
 }
 
 func (h Headers) Parse(data []byte) (n int, done bool, err error) {
@@ -56,25 +108,29 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 	// only parse the first line
 	line := lines[0]
 
-	split := strings.Split(line, ": ")
+	// the value can contain colons of its own, e.g. a URL
+	name, rawValue, found := strings.Cut(line, ":")
 
-	if len(split) != 2 {
+	if !found {
 		return 0, false, errors.New("Invalid header, expected <key>: <value> format: " + line)
 	}
 
-	if strings.HasSuffix(split[0], " ") {
+	if strings.HasSuffix(name, " ") {
 		return 0, false, errors.New("Invalid header, space after key: " + line)
 	}
 
-	key := strings.ToLower(strings.TrimSpace(split[0]))
+	key := strings.ToLower(strings.TrimSpace(name))
 
 	if !containsOnlyValidCharacters(key) {
 		return 0, false, errors.New("Invalid header key, only alphanumeric and certain special characters are allowed: " + key)
 	}
 
-	value := strings.TrimSpace(split[1])
+	value := strings.TrimSpace(rawValue)
 
-	if len(h[key]) > 0 {
+	if len(h[key]) > 0 && key == "cookie" {
+		// Cookie lines are joined with "; " rather than ", " (RFC 6265 5.4)
+		h[key] += "; " + value
+	} else if len(h[key]) > 0 {
 		// If the header already exists, append the new value
 		h[key] += ", " + value
 	} else {
@@ -77,4 +77,29 @@ func TestParse(t *testing.T) {
 	require.NotNil(t, headers)
 	assert.Equal(t, "lane-loves-go, prime-loves-zig", headers["set-person"])
 	assert.False(t, done)
+
+	// Test: multiple cookie headers use the cookie delimiter
+	headers = NewHeaders()
+	_, _, err = headers.Parse([]byte("Cookie: a=1\r\n\r\n"))
+	require.NoError(t, err)
+	_, _, err = headers.Parse([]byte("Cookie: b=2; c=3\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "a=1; b=2; c=3", headers["cookie"])
+}
+
+func TestSetDelete(t *testing.T) {
+	// Test: Get, Set and Delete ignore key casing
+	headers := Headers{"Content-Type": "text/plain", "content-length": "3"}
+	assert.Equal(t, "text/plain", headers.Get("content-type"))
+	headers.Set("content-type", "text/html")
+	assert.Equal(t, Headers{"content-type": "text/html", "content-length": "3"}, headers)
+	headers.Delete("Content-Length")
+	assert.Equal(t, Headers{"content-type": "text/html"}, headers)
+}
+
+func TestCanonical(t *testing.T) {
+	assert.Equal(t, "Content-Type", Canonical("content-type"))
+	assert.Equal(t, "X-Content-Sha256", Canonical("X-CONTENT-SHA256"))
+	assert.Equal(t, "ETag", Canonical("etag"))
+	assert.Equal(t, "WWW-Authenticate", Canonical("www-authenticate"))
 }
@@ -0,0 +1,168 @@
+package mtls
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"strings"
+
+	"github.com/jsleep/httpfromtcp/internal/request"
+	"github.com/jsleep/httpfromtcp/internal/response"
+	"github.com/jsleep/httpfromtcp/internal/server"
+)
+
+// Identity is who a client certificate says the caller is.
+type Identity struct {
+	CommonName     string
+	Organization   []string
+	DNSNames       []string
+	URIs           []string
+	EmailAddresses []string
+	Certificate    *x509.Certificate
+}
+
+// Names lists every name the identity can be matched by, each prefixed
+// with its kind: "cn:", "dns:", "uri:", "email:" or "o:".
+func (id *Identity) Names() []string {
+	var names []string
+	if id.CommonName != "" {
+		names = append(names, "cn:"+id.CommonName)
+	}
+	for _, o := range id.Organization {
+		names = append(names, "o:"+o)
+	}
+	for _, dns := range id.DNSNames {
+		names = append(names, "dns:"+dns)
+	}
+	for _, uri := range id.URIs {
+		names = append(names, "uri:"+uri)
+	}
+	for _, email := range id.EmailAddresses {
+		names = append(names, "email:"+email)
+	}
+	return names
+}
+
+func identityFromCertificate(cert *x509.Certificate) *Identity {
+	id := &Identity{
+		CommonName:     cert.Subject.CommonName,
+		Organization:   cert.Subject.Organization,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		Certificate:    cert,
+	}
+	for _, uri := range cert.URIs {
+		id.URIs = append(id.URIs, uri.String())
+	}
+	return id
+}
+
+// RequireClientCertificates sets up config so the TLS handshake asks for a
+// client certificate and verifies it against roots. Clients without one
+// still connect, so Authenticator can answer them with a 403 rather than a
+// failed handshake.
+func RequireClientCertificates(config *tls.Config, roots *x509.CertPool) *tls.Config {
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+	config.ClientCAs = roots
+	return config
+}
+
+// Authenticator only lets requests through that present a valid client
+// certificate allowed by its policy.
+type Authenticator struct {
+	// Roots verifies client certificates in the middleware. When nil the
+	// chains verified during the handshake (tls.Config.ClientCAs) are used.
+	Roots *x509.CertPool
+
+	// Allow lists the identities that may pass, as "cn:", "dns:", "uri:",
+	// "email:" or "o:" prefixed names (see Identity.Names). A DNS name may
+	// be a wildcard like "dns:*.internal". Empty allows any verified client.
+	Allow []string
+
+	// Authorize, when set, makes the final decision for a verified client
+	// that passed Allow, e.g. to restrict some identities to some paths.
+	Authorize func(id *Identity, req *request.Request) bool
+}
+
+// identityKey keys an authenticator's identity in the request context.
+type identityKey struct {
+	a *Authenticator
+}
+
+// Identity returns the identity of a request that passed Middleware.
+func (a *Authenticator) Identity(req *request.Request) *Identity {
+	id, _ := req.Context().Value(identityKey{a}).(*Identity)
+	return id
+}
+
+func (a *Authenticator) Middleware(next server.Handler) server.Handler {
+	return func(w response.Writer, req *request.Request) *server.HandlerError {
+		id, message := a.authenticate(req)
+		if id == nil {
+			return &server.HandlerError{Code: 403, Message: message}
+		}
+
+		return next(w, req.WithContext(context.WithValue(req.Context(), identityKey{a}, id)))
+	}
+}
+
+func (a *Authenticator) authenticate(req *request.Request) (*Identity, string) {
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		return nil, "client certificate required"
+	}
+
+	leaf := req.TLS.PeerCertificates[0]
+	if a.Roots != nil {
+		intermediates := x509.NewCertPool()
+		for _, cert := range req.TLS.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+		_, err := leaf.Verify(x509.VerifyOptions{
+			Roots:         a.Roots,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		})
+		if err != nil {
+			return nil, "client certificate not trusted"
+		}
+	} else if len(req.TLS.VerifiedChains) == 0 {
+		return nil, "client certificate not trusted"
+	}
+
+	id := identityFromCertificate(leaf)
+	if len(a.Allow) > 0 && !a.allowed(id) {
+		return nil, "client certificate not allowed"
+	}
+	if a.Authorize != nil && !a.Authorize(id, req) {
+		return nil, "client certificate not allowed"
+	}
+	return id, ""
+}
+
+func (a *Authenticator) allowed(id *Identity) bool {
+	for _, name := range id.Names() {
+		for _, allowed := range a.Allow {
+			if matchName(allowed, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchName(pattern string, name string) bool {
+	if strings.EqualFold(pattern, name) {
+		return true
+	}
+	// "dns:*.internal" matches one extra label, like a wildcard certificate
+	suffix, found := strings.CutPrefix(pattern, "dns:*.")
+	if !found {
+		return false
+	}
+	host, isDNS := strings.CutPrefix(name, "dns:")
+	if !isDNS {
+		return false
+	}
+	_, parent, found := strings.Cut(host, ".")
+	return found && strings.EqualFold(parent, suffix)
+}
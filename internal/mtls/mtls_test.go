@@ -0,0 +1,152 @@
+package mtls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jsleep/httpfromtcp/internal/request"
+	"github.com/jsleep/httpfromtcp/internal/response"
+	"github.com/jsleep/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type issuer struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newCertificate creates a certificate from template, signed by parent or
+// self-signed when parent is nil.
+func newCertificate(t *testing.T, template *x509.Certificate, parent *issuer) issuer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return issuer{cert: cert, key: key}
+}
+
+func newCA(t *testing.T, name string) issuer {
+	return newCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, nil)
+}
+
+func tlsCertificate(i issuer) tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{i.cert.Raw}, PrivateKey: i.key, Leaf: i.cert}
+}
+
+func TestAuthenticator(t *testing.T) {
+	ca := newCA(t, "internal CA")
+	otherCA := newCA(t, "other CA")
+	serverCert := newCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "localhost"},
+		DNSNames:    []string{"localhost"},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, &ca)
+	spiffe, _ := url.Parse("spiffe://internal/billing")
+	billing := newCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "billing", Organization: []string{"payments"}},
+		DNSNames:    []string{"billing.svc.internal"},
+		URIs:        []*url.URL{spiffe},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, &ca)
+	reporting := newCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "reporting"},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, &ca)
+	intruder := newCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "billing"},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, &otherCA)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	auth := &Authenticator{Roots: roots, Allow: []string{"dns:*.svc.internal", "uri:spiffe://internal/reporting"}}
+	handler := auth.Middleware(func(w response.Writer, req *request.Request) *server.HandlerError {
+		body := strings.Join(auth.Identity(req).Names(), ",")
+		w.WriteStatusLine(200)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody([]byte(body))
+		return nil
+	})
+
+	// ask for any client certificate so the middleware does the checking
+	config := &tls.Config{Certificates: []tls.Certificate{tlsCertificate(serverCert)}, ClientAuth: tls.RequestClientCert}
+	srv, err := server.ServeTLS(0, handler, config)
+	require.NoError(t, err)
+	defer srv.Close()
+
+	get := func(client *issuer) string {
+		clientConfig := &tls.Config{RootCAs: roots, ServerName: "localhost"}
+		if client != nil {
+			clientConfig.Certificates = []tls.Certificate{tlsCertificate(*client)}
+		}
+		conn, err := tls.Dial("tcp", srv.Listener.Addr().String(), clientConfig)
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		require.NoError(t, err)
+		resp, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		return string(resp)
+	}
+
+	// Test: an allowed client gets through with its identity attached
+	resp := get(&billing)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK"))
+	assert.True(t, strings.HasSuffix(resp, "cn:billing,o:payments,dns:billing.svc.internal,uri:spiffe://internal/billing"))
+
+	// Test: a trusted client that isn't on the allowlist
+	resp = get(&reporting)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 403 Forbidden"))
+	assert.Contains(t, resp, "client certificate not allowed")
+
+	// Test: a certificate from another CA
+	resp = get(&intruder)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 403 Forbidden"))
+	assert.Contains(t, resp, "client certificate not trusted")
+
+	// Test: no certificate at all
+	resp = get(nil)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 403 Forbidden"))
+	assert.Contains(t, resp, "client certificate required")
+}
+
+func TestMatchName(t *testing.T) {
+	assert.True(t, matchName("cn:billing", "cn:billing"))
+	assert.True(t, matchName("dns:*.internal", "dns:svc.internal"))
+	assert.False(t, matchName("dns:*.internal", "dns:a.svc.internal"))
+	assert.False(t, matchName("dns:*.internal", "cn:x.internal"))
+	assert.False(t, matchName("cn:billing", "cn:billing-admin"))
+}
@@ -0,0 +1,199 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jsleep/httpfromtcp/internal/headers"
+	"github.com/jsleep/httpfromtcp/internal/request"
+	"github.com/jsleep/httpfromtcp/internal/response"
+	"github.com/jsleep/httpfromtcp/internal/server"
+)
+
+// DefaultDialTimeout is how long a CONNECT waits for its destination when
+// Forward doesn't say.
+const DefaultDialTimeout = 10 * time.Second
+
+// Forward is an outbound proxy. Plain HTTP requests with an absolute-form
+// target, "GET http://example.com/ HTTP/1.1", are sent on to that URL.
+// "CONNECT example.com:443" opens a tunnel to the destination, through
+// which the client speaks whatever it likes, usually TLS.
+type Forward struct {
+	// Allow lists the destination hosts that can be reached and Deny the
+	// ones that can't, Deny winning. An entry is a host name or IP, or
+	// "*.example.com" for every subdomain of example.com. An empty Allow
+	// lets through every host that isn't denied.
+	Allow []string
+	Deny  []string
+
+	// Authenticate, when set, checks the basic credentials in every
+	// request's Proxy-Authorization header. Clients without valid ones
+	// are challenged with a 407 naming Realm.
+	Authenticate func(username string, password string) bool
+	Realm        string
+
+	// Transport makes the forwarded HTTP requests. When nil they're sent
+	// with the client package.
+	Transport http.RoundTripper
+
+	// DialTimeout bounds how long CONNECT waits for the destination. 0
+	// means DefaultDialTimeout.
+	DialTimeout time.Duration
+
+	// Next serves requests that aren't for a proxy, the ones with an
+	// origin-form target. When nil they get a 400.
+	Next server.Handler
+}
+
+// BasicCredentials returns an Authenticate function that accepts the
+// username and password pairs in users.
+func BasicCredentials(users map[string]string) func(username string, password string) bool {
+	return func(username string, password string) bool {
+		want, ok := users[username]
+		if !ok {
+			return false
+		}
+		return subtle.ConstantTimeCompare([]byte(password), []byte(want)) == 1
+	}
+}
+
+func (f *Forward) Serve(w response.Writer, req *request.Request) *server.HandlerError {
+	line := req.RequestLine
+	if line.Method != "CONNECT" && line.TargetForm != request.AbsoluteForm {
+		if f.Next != nil {
+			return f.Next(w, req)
+		}
+		return &server.HandlerError{Code: 400, Message: "expected an absolute URL or CONNECT"}
+	}
+
+	if !f.authorized(req) {
+		realm := f.Realm
+		if realm == "" {
+			realm = "proxy"
+		}
+		w.OnWriteHeaders(func(w *response.Writer, h headers.Headers) {
+			h.Set("Proxy-Authenticate", `Basic realm="`+realm+`"`)
+		})
+		(&server.HandlerError{Code: 407, Message: "proxy authentication required"}).Write(w, req)
+		return nil
+	}
+
+	host := line.Authority
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	if !f.allowed(host) {
+		return &server.HandlerError{Code: 403, Message: "destination not allowed: " + host}
+	}
+
+	if line.Method == "CONNECT" {
+		return f.tunnel(&w, req)
+	}
+	return f.forward(&w, req)
+}
+
+func (f *Forward) authorized(req *request.Request) bool {
+	if f.Authenticate == nil {
+		return true
+	}
+	scheme, credentials, _ := strings.Cut(req.Headers.Get("Proxy-Authorization"), " ")
+	if !strings.EqualFold(scheme, "Basic") {
+		return false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(credentials))
+	if err != nil {
+		return false
+	}
+	username, password, ok := strings.Cut(string(decoded), ":")
+	return ok && f.Authenticate(username, password)
+}
+
+func (f *Forward) allowed(host string) bool {
+	for _, pattern := range f.Deny {
+		if matchHost(pattern, host) {
+			return false
+		}
+	}
+	if len(f.Allow) == 0 {
+		return true
+	}
+	for _, pattern := range f.Allow {
+		if matchHost(pattern, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchHost compares host names case-insensitively. A pattern starting
+// with "*." matches subdomains at any depth, but not the domain itself.
+func matchHost(pattern string, host string) bool {
+	pattern, host = strings.ToLower(pattern), strings.ToLower(host)
+	if domain, ok := strings.CutPrefix(pattern, "*."); ok {
+		return strings.HasSuffix(host, "."+domain)
+	}
+	return pattern == host
+}
+
+// forward sends an absolute-form request on to the URL it names.
+func (f *Forward) forward(w *response.Writer, req *request.Request) *server.HandlerError {
+	target := req.RequestLine.RequestTarget
+	if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+		return &server.HandlerError{Code: 400, Message: "only http and https URLs can be proxied"}
+	}
+	out, err := outboundRequest(req, target)
+	if err != nil {
+		return &server.HandlerError{Code: 400, Message: err.Error()}
+	}
+
+	transport := f.Transport
+	if transport == nil {
+		transport = defaultTransport
+	}
+	resp, err := transport.RoundTrip(out)
+	if err != nil {
+		return upstreamError(req, err)
+	}
+	return respond(w, req, resp)
+}
+
+// tunnel connects to the CONNECT destination, answers 200 and then copies
+// bytes both ways until either side hangs up.
+func (f *Forward) tunnel(w *response.Writer, req *request.Request) *server.HandlerError {
+	timeout := f.DialTimeout
+	if timeout == 0 {
+		timeout = DefaultDialTimeout
+	}
+	dialer := net.Dialer{Timeout: timeout}
+	upstream, err := dialer.DialContext(req.Context(), "tcp", req.RequestLine.Authority)
+	if err != nil {
+		return upstreamError(req, err)
+	}
+	defer upstream.Close()
+
+	conn, buffered, err := w.Hijack()
+	if err != nil {
+		return server.NewHandlerError(err)
+	}
+	defer conn.Close()
+
+	// a 200 to CONNECT has no body, so it has no framing headers either
+	if err := w.WriteStatusLine(200); err != nil {
+		return nil
+	}
+	if err := w.WriteHeaders(headers.NewHeaders()); err != nil {
+		return nil
+	}
+	if len(buffered) > 0 {
+		if _, err := upstream.Write(buffered); err != nil {
+			return nil
+		}
+	}
+	join(conn, upstream)
+	return nil
+}
@@ -0,0 +1,168 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jsleep/httpfromtcp/internal/request"
+	"github.com/jsleep/httpfromtcp/internal/response"
+	"github.com/jsleep/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startForward serves f and returns a client that uses it as its proxy,
+// with the TLS settings of upstream if it's given.
+func startForward(t *testing.T, f *Forward, upstream *httptest.Server, userinfo *url.Userinfo) (*http.Client, string) {
+	srv, err := server.Serve(0, f.Serve)
+	require.NoError(t, err)
+	t.Cleanup(func() { srv.Close() })
+	proxyURL := &url.URL{Scheme: "http", Host: "127.0.0.1:" + strconv.Itoa(srv.Listener.Addr().(*net.TCPAddr).Port), User: userinfo}
+
+	transport := &http.Transport{}
+	if upstream != nil {
+		transport = upstream.Client().Transport.(*http.Transport).Clone()
+	}
+	transport.Proxy = http.ProxyURL(proxyURL)
+	t.Cleanup(transport.CloseIdleConnections)
+	return &http.Client{Transport: transport}, proxyURL.Host
+}
+
+func TestForwardHTTP(t *testing.T) {
+	var seen *http.Request
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r
+		w.Write([]byte("hello from " + r.URL.Path))
+	}))
+	defer upstream.Close()
+	client, _ := startForward(t, &Forward{Authenticate: BasicCredentials(map[string]string{"dev": "s3cret"})}, nil, url.UserPassword("dev", "s3cret"))
+
+	// Test: an absolute-form request is sent on, credentials and all stripped
+	resp, err := client.Get(upstream.URL + "/path?q=1")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "hello from /path", string(body))
+	assert.Equal(t, "q=1", seen.URL.RawQuery)
+	assert.Empty(t, seen.Header.Get("Proxy-Authorization"))
+}
+
+func TestForwardAuth(t *testing.T) {
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	defer upstream.Close()
+	f := &Forward{Authenticate: BasicCredentials(map[string]string{"dev": "s3cret"}), Realm: "sandbox"}
+
+	// Test: no credentials get a challenge
+	client, _ := startForward(t, f, nil, nil)
+	resp, err := client.Get(upstream.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 407, resp.StatusCode)
+	assert.Equal(t, `Basic realm="sandbox"`, resp.Header.Get("Proxy-Authenticate"))
+
+	// Test: and so do wrong ones
+	client, _ = startForward(t, f, nil, url.UserPassword("dev", "guess"))
+	resp, err = client.Get(upstream.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 407, resp.StatusCode)
+}
+
+func TestConnectTunnel(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secure " + r.URL.Path))
+	}))
+	defer upstream.Close()
+	client, _ := startForward(t, &Forward{Allow: []string{"127.0.0.1"}}, upstream, nil)
+
+	// Test: TLS goes through the tunnel end to end, twice on one connection
+	for _, path := range []string{"/one", "/two"} {
+		resp, err := client.Get(upstream.URL + path)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		assert.Equal(t, "secure "+path, string(body))
+	}
+}
+
+func TestConnectHalfClose(t *testing.T) {
+	// upstream reads everything it's sent, then replies and hangs up
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		sent, _ := io.ReadAll(conn)
+		time.Sleep(20 * time.Millisecond)
+		conn.Write([]byte("got " + string(sent)))
+	}()
+	_, proxyAddr := startForward(t, &Forward{}, nil, nil)
+
+	conn, err := net.Dial("tcp", proxyAddr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("CONNECT " + ln.Addr().String() + " HTTP/1.1\r\nHost: " + ln.Addr().String() + "\r\n\r\n"))
+	require.NoError(t, err)
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+
+	// Test: the reply to a client that's done sending still arrives whole
+	_, err = conn.Write([]byte("request"))
+	require.NoError(t, err)
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+	reply, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "got request", string(reply))
+}
+
+func TestForwardAllowDeny(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.NotFoundHandler())
+	defer upstream.Close()
+
+	// Test: a denied destination is refused, tunneled or not
+	client, _ := startForward(t, &Forward{Deny: []string{"127.0.0.1"}}, upstream, nil)
+	_, err := client.Get(upstream.URL)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Forbidden")
+
+	client, _ = startForward(t, &Forward{Allow: []string{"*.example.com"}}, nil, nil)
+	resp, err := client.Get("http://127.0.0.1:1/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 403, resp.StatusCode)
+
+	// Test: subdomain patterns
+	assert.True(t, matchHost("*.example.com", "api.EXAMPLE.com"))
+	assert.True(t, matchHost("*.example.com", "a.b.example.com"))
+	assert.False(t, matchHost("*.example.com", "example.com"))
+	assert.False(t, matchHost("*.example.com", "badexample.com"))
+}
+
+func TestForwardNext(t *testing.T) {
+	next := func(w response.Writer, req *request.Request) *server.HandlerError {
+		return &server.HandlerError{Code: 404, Message: "not a proxy request"}
+	}
+
+	// Test: origin-form requests go to Next
+	_, proxyHost := startForward(t, &Forward{Next: next}, nil, nil)
+	resp, err := http.Get("http://" + proxyHost + "/status")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 404, resp.StatusCode)
+}
@@ -0,0 +1,389 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jsleep/httpfromtcp/internal/request"
+	"github.com/jsleep/httpfromtcp/internal/response"
+	"github.com/jsleep/httpfromtcp/internal/server"
+)
+
+// Backend is one upstream server in a Pool.
+type Backend struct {
+	URL *url.URL
+
+	down         atomic.Bool  // failed its last health check
+	ejectedUntil atomic.Int64 // unix nanoseconds
+	failures     atomic.Int64 // consecutive
+	active       atomic.Int64
+	requests     atomic.Int64
+}
+
+// available reports whether b may be sent requests: it passed its last
+// health check and isn't ejected.
+func (b *Backend) available(now time.Time) bool {
+	return !b.down.Load() && now.UnixNano() >= b.ejectedUntil.Load()
+}
+
+// ActiveRequests is how many requests b is handling right now.
+func (b *Backend) ActiveRequests() int64 {
+	return b.active.Load()
+}
+
+// Strategy picks the backend for a request from the ones available, of
+// which there is at least one.
+type Strategy interface {
+	Pick(backends []*Backend, req *request.Request) *Backend
+}
+
+// RoundRobin takes turns.
+type RoundRobin struct {
+	next atomic.Uint64
+}
+
+func (r *RoundRobin) Pick(backends []*Backend, req *request.Request) *Backend {
+	n := r.next.Add(1) - 1
+	return backends[n%uint64(len(backends))]
+}
+
+// LeastConnections picks the backend with the fewest requests in flight,
+// which suits requests that take very different amounts of time.
+type LeastConnections struct{}
+
+func (LeastConnections) Pick(backends []*Backend, req *request.Request) *Backend {
+	best := backends[0]
+	for _, b := range backends[1:] {
+		if b.ActiveRequests() < best.ActiveRequests() {
+			best = b
+		}
+	}
+	return best
+}
+
+// ConsistentHash sends requests with the same key to the same backend, so
+// per-client state on a backend stays useful. The key is the Header, else
+// the Cookie, else the client's IP. It uses rendezvous hashing: when a
+// backend becomes unavailable only its keys move elsewhere.
+type ConsistentHash struct {
+	Header string
+	Cookie string
+}
+
+func (c ConsistentHash) key(req *request.Request) string {
+	if c.Header != "" {
+		if value := req.Headers.Get(c.Header); value != "" {
+			return value
+		}
+	}
+	if c.Cookie != "" {
+		if value, ok := req.Cookie(c.Cookie); ok {
+			return value
+		}
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+func (c ConsistentHash) Pick(backends []*Backend, req *request.Request) *Backend {
+	key := c.key(req)
+	var best *Backend
+	var bestScore uint64
+	for _, b := range backends {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write([]byte(b.URL.String()))
+		if score := h.Sum64(); best == nil || score > bestScore {
+			best, bestScore = b, score
+		}
+	}
+	return best
+}
+
+const (
+	DefaultHealthCheckInterval = 10 * time.Second
+	DefaultHealthCheckTimeout  = 2 * time.Second
+)
+
+// Pool is a set of interchangeable upstreams for a Proxy.
+type Pool struct {
+	Backends []*Backend
+
+	// Strategy picks a backend for each request. nil means round-robin.
+	Strategy Strategy
+
+	// HealthCheckPath is requested on every backend's host each
+	// HealthCheckInterval by Proxy.HealthCheck. A backend is up while it
+	// answers with a 2xx or 3xx status within HealthCheckTimeout. 0 means
+	// DefaultHealthCheckInterval and DefaultHealthCheckTimeout.
+	HealthCheckPath     string
+	HealthCheckInterval time.Duration
+	HealthCheckTimeout  time.Duration
+
+	// MaxFailures consecutive failed requests eject a backend for
+	// EjectDuration. A failure is a connection error or a 502, 503 or 504.
+	// 0 never ejects.
+	MaxFailures   int
+	EjectDuration time.Duration
+
+	// Retries is how many other backends an idempotent request is tried
+	// on after a failure.
+	Retries int
+
+	roundRobin RoundRobin
+}
+
+// NewPool returns a round-robin pool of targets, absolute http or https
+// URLs, that ejects a backend for 30s after 3 failures in a row and
+// retries idempotent requests once.
+func NewPool(targets ...string) (*Pool, error) {
+	if len(targets) == 0 {
+		return nil, errors.New("pool needs at least one target")
+	}
+	p := &Pool{
+		HealthCheckPath:     "/health",
+		HealthCheckInterval: DefaultHealthCheckInterval,
+		HealthCheckTimeout:  DefaultHealthCheckTimeout,
+		MaxFailures:         3,
+		EjectDuration:       30 * time.Second,
+		Retries:             1,
+	}
+	for _, target := range targets {
+		proxy, err := New(target)
+		if err != nil {
+			return nil, err
+		}
+		p.Backends = append(p.Backends, &Backend{URL: proxy.Target})
+	}
+	return p, nil
+}
+
+// pick chooses among the available backends not already tried, or returns
+// nil when there are none.
+func (p *Pool) pick(req *request.Request, tried map[*Backend]bool) *Backend {
+	now := time.Now()
+	var candidates []*Backend
+	for _, b := range p.Backends {
+		if !tried[b] && b.available(now) {
+			candidates = append(candidates, b)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	if p.Strategy == nil {
+		return p.roundRobin.Pick(candidates, req)
+	}
+	return p.Strategy.Pick(candidates, req)
+}
+
+func (p *Pool) reportFailure(b *Backend) {
+	failures := b.failures.Add(1)
+	if p.MaxFailures > 0 && failures >= int64(p.MaxFailures) {
+		fmt.Println("Ejecting backend after", failures, "failures:", b.URL)
+		b.ejectedUntil.Store(time.Now().Add(p.EjectDuration).UnixNano())
+		b.failures.Store(0)
+	}
+}
+
+func (p *Pool) reportSuccess(b *Backend) {
+	b.failures.Store(0)
+}
+
+// idempotent methods can be sent again without changing the outcome
+// (RFC 9110 section 9.2.2).
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+func isUpstreamFailure(status int) bool {
+	return status == 502 || status == 503 || status == 504
+}
+
+func (p *Proxy) servePool(w *response.Writer, req *request.Request) *server.HandlerError {
+	attempts := 1
+	if idempotent(req.RequestLine.Method) {
+		attempts += p.Pool.Retries
+	}
+
+	failure := &server.HandlerError{Code: 503, Message: "no healthy upstream"}
+	tried := map[*Backend]bool{}
+	for attempt := 1; attempt <= attempts; attempt++ {
+		backend := p.Pool.pick(req, tried)
+		if backend == nil {
+			break
+		}
+		tried[backend] = true
+
+		out, err := p.upstreamRequest(req, backend.URL)
+		if err != nil {
+			return &server.HandlerError{Code: 400, Message: err.Error()}
+		}
+
+		backend.requests.Add(1)
+		backend.active.Add(1)
+		resp, err := p.transport().RoundTrip(out)
+		if err != nil {
+			backend.active.Add(-1)
+			if req.Context().Err() != nil {
+				return nil
+			}
+			p.Pool.reportFailure(backend)
+			failure = upstreamError(req, err)
+			continue
+		}
+
+		if isUpstreamFailure(resp.StatusCode) {
+			p.Pool.reportFailure(backend)
+			if attempt < attempts {
+				// nothing has been sent to the client yet, so another
+				// backend can still answer instead
+				resp.Body.Close()
+				backend.active.Add(-1)
+				failure = &server.HandlerError{Code: response.StatusCode(resp.StatusCode), Message: "upstream failed"}
+				continue
+			}
+		} else {
+			p.Pool.reportSuccess(backend)
+		}
+
+		if p.ModifyResponse != nil {
+			p.ModifyResponse(resp, req)
+		}
+		handlerErr := respond(w, req, resp)
+		backend.active.Add(-1)
+		return handlerErr
+	}
+	return failure
+}
+
+// HealthCheck checks every backend of the Pool now and then every
+// HealthCheckInterval, until ctx is done. The checks go through Transport,
+// as requests do, but never the Cache. Run it in its own goroutine.
+func (p *Proxy) HealthCheck(ctx context.Context) {
+	pool := p.Pool
+	if pool == nil || pool.HealthCheckPath == "" {
+		return
+	}
+	transport := p.upstreamTransport()
+	ticker := time.NewTicker(pool.healthCheckInterval())
+	defer ticker.Stop()
+	for {
+		pool.checkAll(ctx, transport)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Pool) healthCheckInterval() time.Duration {
+	if p.HealthCheckInterval <= 0 {
+		return DefaultHealthCheckInterval
+	}
+	return p.HealthCheckInterval
+}
+
+func (p *Pool) healthCheckTimeout() time.Duration {
+	if p.HealthCheckTimeout <= 0 {
+		return DefaultHealthCheckTimeout
+	}
+	return p.HealthCheckTimeout
+}
+
+func (p *Pool) checkAll(ctx context.Context, transport http.RoundTripper) {
+	var wg sync.WaitGroup
+	for _, b := range p.Backends {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			up := p.check(ctx, transport, b)
+			if wasDown := b.down.Swap(!up); wasDown == up {
+				fmt.Println("Backend", b.URL, "health changed, up:", up)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (p *Pool) check(ctx context.Context, transport http.RoundTripper, b *Backend) bool {
+	ctx, cancel := context.WithTimeout(ctx, p.healthCheckTimeout())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", b.URL.Scheme+"://"+b.URL.Host+p.HealthCheckPath, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode >= 200 && resp.StatusCode < 400
+}
+
+// BackendStatus is a backend's state as shown by AdminHandler.
+type BackendStatus struct {
+	URL                 string     `json:"url"`
+	Healthy             bool       `json:"healthy"`
+	EjectedUntil        *time.Time `json:"ejected_until,omitempty"`
+	ActiveRequests      int64      `json:"active_requests"`
+	Requests            int64      `json:"requests"`
+	ConsecutiveFailures int64      `json:"consecutive_failures"`
+}
+
+func (p *Pool) Status() []BackendStatus {
+	now := time.Now()
+	statuses := make([]BackendStatus, 0, len(p.Backends))
+	for _, b := range p.Backends {
+		status := BackendStatus{
+			URL:                 b.URL.String(),
+			Healthy:             !b.down.Load(),
+			ActiveRequests:      b.active.Load(),
+			Requests:            b.requests.Load(),
+			ConsecutiveFailures: b.failures.Load(),
+		}
+		if until := time.Unix(0, b.ejectedUntil.Load()); until.After(now) {
+			status.EjectedUntil = &until
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// AdminHandler serves the state of every backend as JSON.
+func (p *Pool) AdminHandler(w response.Writer, req *request.Request) *server.HandlerError {
+	available := 0
+	now := time.Now()
+	for _, b := range p.Backends {
+		if b.available(now) {
+			available++
+		}
+	}
+	body := map[string]any{
+		"available": strconv.Itoa(available) + "/" + strconv.Itoa(len(p.Backends)),
+		"backends":  p.Status(),
+	}
+	if err := w.WriteJSON(200, body); err != nil {
+		return server.NewHandlerError(err)
+	}
+	return nil
+}
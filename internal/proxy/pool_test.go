@@ -0,0 +1,255 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jsleep/httpfromtcp/internal/headers"
+	"github.com/jsleep/httpfromtcp/internal/request"
+	"github.com/jsleep/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// namedUpstream answers every request with its name, and /health with
+// healthStatus.
+func namedUpstream(t *testing.T, name string, healthStatus int) *httptest.Server {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			w.WriteHeader(healthStatus)
+			return
+		}
+		w.Write([]byte(name))
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+// startPool serves a proxy over pool and returns its base URL.
+func startPool(t *testing.T, pool *Pool) string {
+	p := &Proxy{Pool: pool}
+	srv, err := server.Serve(0, p.Serve)
+	require.NoError(t, err)
+	t.Cleanup(func() { srv.Close() })
+	return "http://127.0.0.1:" + strconv.Itoa(srv.Listener.Addr().(*net.TCPAddr).Port)
+}
+
+func get(t *testing.T, method string, url string) (int, string) {
+	req, err := http.NewRequest(method, url, nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(body)
+}
+
+func TestRoundRobin(t *testing.T) {
+	a, b, c := namedUpstream(t, "a", 200), namedUpstream(t, "b", 200), namedUpstream(t, "c", 200)
+	pool, err := NewPool(a.URL, b.URL, c.URL)
+	require.NoError(t, err)
+	base := startPool(t, pool)
+
+	// Test: each backend takes its turn
+	var seen []string
+	for range 6 {
+		status, body := get(t, "GET", base+"/")
+		require.Equal(t, 200, status)
+		seen = append(seen, body)
+	}
+	assert.Equal(t, []string{"a", "b", "c", "a", "b", "c"}, seen)
+}
+
+func backends(urls ...string) []*Backend {
+	var bs []*Backend
+	for _, u := range urls {
+		parsed, _ := url.Parse(u)
+		bs = append(bs, &Backend{URL: parsed})
+	}
+	return bs
+}
+
+func TestLeastConnections(t *testing.T) {
+	bs := backends("http://a", "http://b", "http://c")
+	bs[0].active.Store(3)
+	bs[1].active.Store(1)
+	bs[2].active.Store(2)
+
+	// Test: the least busy backend
+	assert.Same(t, bs[1], LeastConnections{}.Pick(bs, &request.Request{}))
+}
+
+func TestConsistentHash(t *testing.T) {
+	bs := backends("http://a", "http://b", "http://c", "http://d")
+	strategy := ConsistentHash{Header: "X-User", Cookie: "session"}
+	forUser := func(user string) *request.Request {
+		return &request.Request{Headers: headers.Headers{"x-user": user}, RemoteAddr: "10.0.0.1:5000"}
+	}
+
+	// Test: the same key lands on the same backend every time
+	first := strategy.Pick(bs, forUser("alice"))
+	for range 10 {
+		assert.Same(t, first, strategy.Pick(bs, forUser("alice")))
+	}
+
+	// Test: keys spread out, and losing a backend only moves its own keys
+	picked := map[string]*Backend{}
+	used := map[*Backend]bool{}
+	for i := range 100 {
+		user := "user" + strconv.Itoa(i)
+		picked[user] = strategy.Pick(bs, forUser(user))
+		used[picked[user]] = true
+	}
+	assert.Len(t, used, 4)
+	remaining := bs[1:]
+	for user, before := range picked {
+		after := strategy.Pick(remaining, forUser(user))
+		if before != bs[0] {
+			assert.Same(t, before, after, user)
+		}
+	}
+
+	// Test: the cookie, then the client IP, when there's no header
+	byCookie := &request.Request{Headers: headers.Headers{"cookie": "session=alice"}, RemoteAddr: "10.0.0.2:5000"}
+	assert.Equal(t, "alice", strategy.key(byCookie))
+	byIP := &request.Request{Headers: headers.NewHeaders(), RemoteAddr: "10.0.0.2:5000"}
+	assert.Equal(t, "10.0.0.2", strategy.key(byIP))
+}
+
+func TestEjectionAndRetry(t *testing.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	alive := namedUpstream(t, "alive", 200)
+	pool, err := NewPool(dead.URL, alive.URL)
+	require.NoError(t, err)
+	pool.MaxFailures = 1
+	pool.EjectDuration = time.Minute
+	base := startPool(t, pool)
+
+	// Test: a GET to the dead backend is retried on the other one
+	status, body := get(t, "GET", base+"/")
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "alive", body)
+
+	// Test: the dead backend is ejected, so everything goes to the live one
+	ejectedUntil := pool.Status()[0].EjectedUntil
+	require.NotNil(t, ejectedUntil)
+	assert.True(t, ejectedUntil.After(time.Now()))
+	for range 3 {
+		_, body := get(t, "POST", base+"/")
+		assert.Equal(t, "alive", body)
+	}
+	assert.Equal(t, int64(1), pool.Status()[0].Requests)
+	assert.Equal(t, int64(4), pool.Status()[1].Requests)
+}
+
+func TestNoRetryForPost(t *testing.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	alive := namedUpstream(t, "alive", 200)
+	pool, err := NewPool(dead.URL, alive.URL)
+	require.NoError(t, err)
+	base := startPool(t, pool)
+
+	// Test: a POST might have been acted on, so it isn't sent again
+	status, _ := get(t, "POST", base+"/")
+	assert.Equal(t, 502, status)
+
+	// Test: with every backend gone there's nothing to try
+	for _, b := range pool.Backends {
+		b.down.Store(true)
+	}
+	status, _ = get(t, "GET", base+"/")
+	assert.Equal(t, 503, status)
+}
+
+func TestHealthCheck(t *testing.T) {
+	sick := namedUpstream(t, "sick", 500)
+	well := namedUpstream(t, "well", 204)
+	pool, err := NewPool(sick.URL, well.URL)
+	require.NoError(t, err)
+
+	// Test: a backend failing its check stops getting requests
+	pool.checkAll(context.Background(), defaultTransport)
+	statuses := pool.Status()
+	assert.False(t, statuses[0].Healthy)
+	assert.True(t, statuses[1].Healthy)
+	assert.Same(t, pool.Backends[1], pool.pick(&request.Request{}, nil))
+
+	// Test: the admin endpoint reports it
+	srv, err := server.Serve(0, pool.AdminHandler)
+	require.NoError(t, err)
+	defer srv.Close()
+	status, body := get(t, "GET", "http://127.0.0.1:"+strconv.Itoa(srv.Listener.Addr().(*net.TCPAddr).Port)+"/")
+	require.Equal(t, 200, status)
+	var state struct {
+		Available string          `json:"available"`
+		Backends  []BackendStatus `json:"backends"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(body), &state))
+	assert.Equal(t, "1/2", state.Available)
+	require.Len(t, state.Backends, 2)
+	assert.Equal(t, sick.URL, state.Backends[0].URL)
+	assert.False(t, state.Backends[0].Healthy)
+	assert.True(t, state.Backends[1].Healthy)
+}
+
+func TestHealthCheckDefaults(t *testing.T) {
+	sick := namedUpstream(t, "sick", 500)
+	well := namedUpstream(t, "well", 204)
+	sickURL, err := url.Parse(sick.URL)
+	require.NoError(t, err)
+	wellURL, err := url.Parse(well.URL)
+	require.NoError(t, err)
+	pool := &Pool{
+		Backends:        []*Backend{{URL: sickURL}, {URL: wellURL}},
+		HealthCheckPath: "/health",
+	}
+
+	// Test: a pool built without NewPool checks with the default interval and timeout
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		(&Proxy{Pool: pool}).HealthCheck(ctx)
+		close(done)
+	}()
+	assert.Eventually(t, func() bool {
+		statuses := pool.Status()
+		return !statuses[0].Healthy && statuses[1].Healthy
+	}, time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+	assert.True(t, pool.Status()[1].Healthy)
+}
+
+func TestHealthCheckTransport(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secure"))
+	}))
+	defer upstream.Close()
+	pool, err := NewPool(upstream.URL)
+	require.NoError(t, err)
+	p := &Proxy{Pool: pool, Transport: upstream.Client().Transport}
+
+	// Test: checks trust what the proxy's Transport trusts, as requests do
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pool.Backends[0].down.Store(true)
+	go p.HealthCheck(ctx)
+	assert.Eventually(t, func() bool { return pool.Status()[0].Healthy }, time.Second, 10*time.Millisecond)
+	srv, err := server.Serve(0, p.Serve)
+	require.NoError(t, err)
+	defer srv.Close()
+	status, body := get(t, "GET", "http://127.0.0.1:"+strconv.Itoa(srv.Listener.Addr().(*net.TCPAddr).Port)+"/")
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "secure", body)
+}
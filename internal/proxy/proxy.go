@@ -0,0 +1,450 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jsleep/httpfromtcp/internal/cache"
+	"github.com/jsleep/httpfromtcp/internal/client"
+	"github.com/jsleep/httpfromtcp/internal/headers"
+	"github.com/jsleep/httpfromtcp/internal/request"
+	"github.com/jsleep/httpfromtcp/internal/response"
+	"github.com/jsleep/httpfromtcp/internal/server"
+)
+
+// hopHeaders describe a single connection rather than the message, so a
+// proxy must not pass them on (RFC 9110 section 7.6.1). Headers named in
+// the Connection header are dropped too.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// defaultTransport sends upstream requests with our own client. It doesn't
+// ask for compression, so encoded bodies pass through untouched.
+var defaultTransport http.RoundTripper = &client.Transport{}
+
+// Proxy forwards requests to an upstream server and streams its responses
+// back.
+type Proxy struct {
+	// Target is where requests go. Request paths are appended to its path.
+	Target *url.URL
+
+	// Pool, when set, spreads requests over several targets instead.
+	Pool *Pool
+
+	// StripPrefix is removed from request paths before they're appended.
+	StripPrefix string
+
+	// PreserveHost sends the client's Host header upstream instead of the
+	// target's host.
+	PreserveHost bool
+
+	// Transport makes the upstream requests. When nil they're sent with
+	// the client package.
+	Transport http.RoundTripper
+
+	// Cache, when set, answers what it can before Transport is used.
+	Cache *cache.Cache
+
+	// ModifyRequest, when set, can change the upstream request just before
+	// it's sent.
+	ModifyRequest func(out *http.Request, in *request.Request)
+
+	// ModifyResponse, when set, can change the upstream response before
+	// it's sent on, e.g. to wrap its body. Trailers it declares in
+	// resp.Trailer are announced, and sent with the values they have once
+	// the body has been read.
+	ModifyResponse func(resp *http.Response, in *request.Request)
+}
+
+// New returns a proxy to target, an absolute http or https URL.
+func New(target string) (*Proxy, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("proxy target must be an absolute http or https URL: " + target)
+	}
+	return &Proxy{Target: u}, nil
+}
+
+// upstreamTransport is Transport, or defaultTransport when it's nil.
+func (p *Proxy) upstreamTransport() http.RoundTripper {
+	if p.Transport == nil {
+		return defaultTransport
+	}
+	return p.Transport
+}
+
+func (p *Proxy) transport() http.RoundTripper {
+	transport := p.upstreamTransport()
+	if p.Cache != nil {
+		return p.Cache.Transport(transport)
+	}
+	return transport
+}
+
+func (p *Proxy) Serve(w response.Writer, req *request.Request) *server.HandlerError {
+	if p.Pool != nil {
+		return p.servePool(&w, req)
+	}
+
+	out, err := p.upstreamRequest(req, p.Target)
+	if err != nil {
+		return &server.HandlerError{Code: 400, Message: err.Error()}
+	}
+
+	resp, err := p.transport().RoundTrip(out)
+	if err != nil {
+		return upstreamError(req, err)
+	}
+	if p.ModifyResponse != nil {
+		p.ModifyResponse(resp, req)
+	}
+	return respond(&w, req, resp)
+}
+
+// upstreamError turns a failed round trip into the error the client sees.
+func upstreamError(req *request.Request, err error) *server.HandlerError {
+	if req.Context().Err() != nil {
+		// the client is gone, there's nobody to answer
+		return nil
+	}
+	fmt.Println("Error proxying request:", err)
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return &server.HandlerError{Code: 504, Message: "upstream timed out"}
+	}
+	return &server.HandlerError{Code: 502, Message: "upstream unavailable"}
+}
+
+// respond passes resp on to the client and closes it.
+func respond(w *response.Writer, req *request.Request, resp *http.Response) *server.HandlerError {
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 101 {
+		return serveUpgrade(w, resp)
+	}
+	copyResponse(w, req, resp)
+	return nil
+}
+
+// upstreamRequest builds the request for req to send to target.
+func (p *Proxy) upstreamRequest(req *request.Request, target *url.URL) (*http.Request, error) {
+	path, query, _ := strings.Cut(req.OriginTarget(), "?")
+	path = strings.TrimPrefix(path, p.StripPrefix)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	upstreamURL := target.Scheme + "://" + target.Host + strings.TrimSuffix(target.Path, "/") + path
+	if target.RawQuery != "" && query != "" {
+		upstreamURL += "?" + target.RawQuery + "&" + query
+	} else if target.RawQuery != "" || query != "" {
+		upstreamURL += "?" + target.RawQuery + query
+	}
+
+	out, err := outboundRequest(req, upstreamURL)
+	if err != nil {
+		return nil, err
+	}
+
+	host := req.Headers.Get("Host")
+	if p.PreserveHost && host != "" {
+		out.Host = host
+	}
+	addForwarded(out, req, host)
+
+	if p.ModifyRequest != nil {
+		p.ModifyRequest(out, req)
+	}
+	return out, nil
+}
+
+// outboundRequest is req, with its body and end-to-end headers, to be
+// sent on to upstreamURL.
+func outboundRequest(req *request.Request, upstreamURL string) (*http.Request, error) {
+	var body io.Reader
+	if len(req.Body) > 0 {
+		body = bytes.NewReader(req.Body)
+	}
+	out, err := http.NewRequestWithContext(req.Context(), req.RequestLine.Method, upstreamURL, body)
+	if err != nil {
+		return nil, err
+	}
+
+	connection := req.Headers.Get("Connection")
+	for name, value := range req.Headers {
+		if isHopHeader(name, connection) || strings.EqualFold(name, "Host") || strings.EqualFold(name, "Content-Length") {
+			continue
+		}
+		out.Header.Set(headers.Canonical(name), value)
+	}
+	// an upgrade is the one hop-by-hop request that has to reach the upstream
+	if upgrade := req.Headers.Get("Upgrade"); upgrade != "" && hasToken(connection, "upgrade") {
+		out.Header.Set("Connection", "Upgrade")
+		out.Header.Set("Upgrade", upgrade)
+	}
+	return out, nil
+}
+
+// addForwarded records the client and how it reached us, both in the
+// de facto X-Forwarded-* headers and the standard Forwarded (RFC 7239).
+func addForwarded(out *http.Request, req *request.Request, host string) {
+	proto := "http"
+	if req.TLS != nil {
+		proto = "https"
+	}
+
+	clientIP, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		clientIP = ""
+	}
+	if clientIP != "" {
+		forwardedFor := clientIP
+		if prior := req.Headers.Get("X-Forwarded-For"); prior != "" {
+			forwardedFor = prior + ", " + clientIP
+		}
+		out.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	out.Header.Set("X-Forwarded-Proto", proto)
+	if host != "" {
+		out.Header.Set("X-Forwarded-Host", host)
+	}
+
+	var element []string
+	if clientIP != "" {
+		node := clientIP
+		if strings.Contains(clientIP, ":") {
+			node = "[" + clientIP + "]"
+		}
+		element = append(element, "for="+forwardedValue(node))
+	}
+	if host != "" {
+		element = append(element, "host="+forwardedValue(host))
+	}
+	element = append(element, "proto="+proto)
+	forwarded := strings.Join(element, ";")
+	if prior := req.Headers.Get("Forwarded"); prior != "" {
+		forwarded = prior + ", " + forwarded
+	}
+	out.Header.Set("Forwarded", forwarded)
+}
+
+// forwardedValue quotes s unless it's a plain token.
+func forwardedValue(s string) string {
+	for _, c := range s {
+		if !isTokenChar(c) {
+			return strconv.Quote(s)
+		}
+	}
+	return s
+}
+
+func isTokenChar(c rune) bool {
+	return c < 0x7f && c > 0x20 && !strings.ContainsRune(`"(),/:;<=>?@[\]{}`, c)
+}
+
+func isHopHeader(name string, connection string) bool {
+	for _, hop := range hopHeaders {
+		if strings.EqualFold(name, hop) {
+			return true
+		}
+	}
+	return hasToken(connection, name)
+}
+
+func hasToken(value string, token string) bool {
+	for _, part := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(part), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// responseHeaders copies the end-to-end headers of resp, queueing its
+// cookies on w since they can't share a line.
+func responseHeaders(w *response.Writer, resp *http.Response) headers.Headers {
+	h := headers.NewHeaders()
+	connection := strings.Join(resp.Header.Values("Connection"), ",")
+	for name, values := range resp.Header {
+		if isHopHeader(name, connection) {
+			continue
+		}
+		if name == "Set-Cookie" {
+			for _, value := range values {
+				w.AddSetCookie(value)
+			}
+			continue
+		}
+		h.Set(name, strings.Join(values, ", "))
+	}
+	return h
+}
+
+func bodyAllowed(req *request.Request, status int) bool {
+	return req.RequestLine.Method != "HEAD" && status >= 200 && status != 204 && status != 304
+}
+
+// copyResponse sends resp on to the client as it arrives. Bodies of known
+// length keep their Content-Length; the rest, and any with trailers, are
+// sent chunked.
+func copyResponse(w *response.Writer, req *request.Request, resp *http.Response) {
+	h := responseHeaders(w, resp)
+	h.Set("Connection", "close")
+
+	if !bodyAllowed(req, resp.StatusCode) {
+		w.WriteStatusLine(response.StatusCode(resp.StatusCode))
+		w.WriteHeaders(h)
+		return
+	}
+
+	chunked := resp.ContentLength < 0 || len(resp.Trailer) > 0
+	if chunked {
+		h.Delete("Content-Length")
+		h.Set("Transfer-Encoding", "chunked")
+		if len(resp.Trailer) > 0 {
+			names := make([]string, 0, len(resp.Trailer))
+			for name := range resp.Trailer {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			h.Set("Trailer", strings.Join(names, ", "))
+		}
+	} else {
+		h.Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	}
+
+	if err := w.WriteStatusLine(response.StatusCode(resp.StatusCode)); err != nil {
+		return
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return
+	}
+
+	buffer := make([]byte, 32*1024)
+	for {
+		n, readErr := resp.Body.Read(buffer)
+		if n > 0 {
+			var err error
+			if chunked {
+				_, err = w.WriteChunkedBody(buffer[:n])
+			} else {
+				_, err = w.WriteBody(buffer[:n])
+			}
+			if err == nil {
+				err = w.Flush()
+			}
+			if err != nil {
+				// the client went away, which cancels the upstream request too
+				return
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			// leave the body unterminated so the client can tell it's cut short
+			fmt.Println("Error reading upstream response:", readErr)
+			return
+		}
+	}
+
+	if chunked {
+		if _, err := w.WriteChunkedBodyDone(); err != nil {
+			return
+		}
+		trailers := headers.NewHeaders()
+		for name, values := range resp.Trailer {
+			if len(values) > 0 {
+				trailers.Set(name, strings.Join(values, ", "))
+			}
+		}
+		w.WriteTrailers(trailers)
+	}
+}
+
+// serveUpgrade finishes a protocol switch, e.g. to WebSocket, by joining
+// the client's connection to the upstream one.
+func serveUpgrade(w *response.Writer, resp *http.Response) *server.HandlerError {
+	upstream, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		return &server.HandlerError{Code: 502, Message: "upstream switched protocols without a connection"}
+	}
+
+	conn, buffered, err := w.Hijack()
+	if err != nil {
+		return server.NewHandlerError(err)
+	}
+	defer conn.Close()
+
+	h := responseHeaders(w, resp)
+	h.Set("Connection", "Upgrade")
+	h.Set("Upgrade", resp.Header.Get("Upgrade"))
+	if err := w.WriteStatusLine(101); err != nil {
+		return nil
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return nil
+	}
+	if len(buffered) > 0 {
+		if _, err := upstream.Write(buffered); err != nil {
+			return nil
+		}
+	}
+
+	join(conn, upstream)
+	return nil
+}
+
+// join copies bytes both ways between a and b until both sides are done.
+// When one side stops sending, the other is told so with CloseWrite where
+// the connection has it, so a client that half-closes still gets the whole
+// reply. Where it doesn't, the other side is closed outright.
+func join(a io.ReadWriter, b io.ReadWriter) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(a, b)
+		closeWrite(a)
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(b, a)
+		closeWrite(b)
+	}()
+	wg.Wait()
+}
+
+// closeWrite shuts down the writing side of c, e.g. a *net.TCPConn or
+// *tls.Conn, or closes c when that can't be done.
+func closeWrite(c io.Writer) {
+	if cw, ok := c.(interface{ CloseWrite() error }); ok {
+		if cw.CloseWrite() == nil {
+			return
+		}
+	}
+	if closer, ok := c.(io.Closer); ok {
+		closer.Close()
+	}
+}
@@ -0,0 +1,211 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jsleep/httpfromtcp/internal/cache"
+	"github.com/jsleep/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startProxy serves a proxy to upstream under /api/ and returns its base URL.
+func startProxy(t *testing.T, upstream string) string {
+	p, err := New(upstream + "/base")
+	require.NoError(t, err)
+	p.StripPrefix = "/api"
+
+	srv, err := server.Serve(0, p.Serve)
+	require.NoError(t, err)
+	t.Cleanup(func() { srv.Close() })
+	return "http://127.0.0.1:" + strconv.Itoa(srv.Listener.Addr().(*net.TCPAddr).Port)
+}
+
+func TestForwardRequest(t *testing.T) {
+	var seen *http.Request
+	var seenBody string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		seen, seenBody = r, string(body)
+		w.Header().Add("Set-Cookie", "a=1; Path=/")
+		w.Header().Add("Set-Cookie", "b=2; HttpOnly")
+		w.Header().Set("Connection", "X-Internal")
+		w.Header().Set("X-Internal", "secret")
+		w.WriteHeader(201)
+		w.Write([]byte("created"))
+	}))
+	defer upstream.Close()
+	base := startProxy(t, upstream.URL)
+
+	req, err := http.NewRequest("PUT", base+"/api/items/7?color=red", strings.NewReader(`{"name":"lamp"}`))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Connection", "X-Hop")
+	req.Header.Set("X-Hop", "drop me")
+	req.Header.Set("X-Forwarded-For", "10.0.0.1")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	// Test: method, path, query, body and end-to-end headers reach the upstream
+	assert.Equal(t, "PUT", seen.Method)
+	assert.Equal(t, "/base/items/7", seen.URL.Path)
+	assert.Equal(t, "color=red", seen.URL.RawQuery)
+	assert.Equal(t, `{"name":"lamp"}`, seenBody)
+	assert.Equal(t, "application/json", seen.Header.Get("Content-Type"))
+
+	// Test: hop-by-hop headers don't
+	assert.Empty(t, seen.Header.Get("X-Hop"))
+
+	// Test: the client is recorded
+	assert.Equal(t, "10.0.0.1, 127.0.0.1", seen.Header.Get("X-Forwarded-For"))
+	assert.Equal(t, "http", seen.Header.Get("X-Forwarded-Proto"))
+	proxyHost := strings.TrimPrefix(base, "http://")
+	assert.Equal(t, proxyHost, seen.Header.Get("X-Forwarded-Host"))
+	assert.Equal(t, `for=127.0.0.1;host="`+proxyHost+`";proto=http`, seen.Header.Get("Forwarded"))
+
+	// Test: status, headers and every cookie come back
+	assert.Equal(t, 201, resp.StatusCode)
+	assert.Equal(t, "201 Created", resp.Status)
+	assert.Len(t, resp.Cookies(), 2)
+	assert.Empty(t, resp.Header.Get("X-Internal"))
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "created", string(body))
+	assert.Equal(t, int64(7), resp.ContentLength)
+}
+
+func TestStreamingAndTrailers(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Checksum")
+		w.WriteHeader(200)
+		w.Write([]byte("first "))
+		w.(http.Flusher).Flush()
+		<-release
+		w.Write([]byte("second"))
+		w.Header().Set("X-Checksum", "abc123")
+	}))
+	defer upstream.Close()
+	defer close(release)
+	base := startProxy(t, upstream.URL)
+
+	resp, err := http.Get(base + "/api/stream")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	// Test: the first part arrives while the upstream is still writing
+	first := make([]byte, len("first "))
+	_, err = io.ReadFull(resp.Body, first)
+	require.NoError(t, err)
+	assert.Equal(t, "first ", string(first))
+	release <- struct{}{}
+
+	// Test: the rest, then the trailers
+	rest, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(rest))
+	assert.Equal(t, "abc123", resp.Trailer.Get("X-Checksum"))
+}
+
+func TestUpstreamDown(t *testing.T) {
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	upstream.Close()
+	base := startProxy(t, upstream.URL)
+
+	// Test: a dead upstream is a bad gateway, not an internal error
+	resp, err := http.Get(base + "/api/anything")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 502, resp.StatusCode)
+}
+
+func TestUpgrade(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "echo" {
+			w.WriteHeader(400)
+			return
+		}
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+		rw.Flush()
+		for {
+			line, err := rw.ReadString('\n')
+			if err != nil {
+				return
+			}
+			rw.WriteString("echo: " + line)
+			rw.Flush()
+		}
+	}))
+	defer upstream.Close()
+	base := startProxy(t, upstream.URL)
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(base, "http://"))
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte("GET /api/echo HTTP/1.1\r\nHost: localhost\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n"))
+	require.NoError(t, err)
+
+	// Test: the switch is passed on and bytes flow both ways
+	reader := bufio.NewReader(conn)
+	status, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols\r\n", status)
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			break
+		}
+	}
+	for _, message := range []string{"hello", "again"} {
+		_, err = conn.Write([]byte(message + "\n"))
+		require.NoError(t, err)
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, "echo: "+message+"\n", line)
+	}
+}
+
+func TestCachedProxy(t *testing.T) {
+	requests := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("cacheable"))
+	}))
+	defer upstream.Close()
+	p, err := New(upstream.URL)
+	require.NoError(t, err)
+	p.Cache = cache.New(cache.NewMemoryStore(1 << 20))
+	srv, err := server.Serve(0, p.Serve)
+	require.NoError(t, err)
+	defer srv.Close()
+	base := "http://127.0.0.1:" + strconv.Itoa(srv.Listener.Addr().(*net.TCPAddr).Port)
+
+	// Test: the second request is answered by the cache, and says so
+	for _, want := range []string{cache.Miss, cache.Hit} {
+		resp, err := http.Get(base + "/thing")
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		assert.Equal(t, "cacheable", string(body))
+		assert.Equal(t, want, resp.Header.Get("X-Cache"))
+	}
+	assert.Equal(t, 1, requests)
+}
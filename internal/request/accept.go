@@ -0,0 +1,190 @@
+package request
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Accept is one element of an Accept-style header, such as
+// "text/html;level=1;q=0.8" or "en-GB;q=0.5".
+type Accept struct {
+	Value  string
+	Q      float64
+	Params map[string]string
+}
+
+// ParseAccept parses an Accept, Accept-Language, Accept-Charset or
+// Accept-Encoding header. Elements come back ordered from most to least
+// preferred; elements with an invalid q-value are dropped.
+func ParseAccept(header string) []Accept {
+	var accepts []Accept
+	for _, part := range strings.Split(header, ",") {
+		if accept, ok := parseElement(part); ok {
+			accepts = append(accepts, accept)
+		}
+	}
+
+	sort.SliceStable(accepts, func(i, j int) bool {
+		return accepts[i].Q > accepts[j].Q
+	})
+	return accepts
+}
+
+// parseElement parses one element of an Accept-style header, or a media
+// type with parameters. Parameter names are lowercased. It reports false for
+// an empty element or one with an invalid q-value.
+func parseElement(part string) (Accept, bool) {
+	fields := strings.Split(part, ";")
+	value := strings.ToLower(strings.TrimSpace(fields[0]))
+	if value == "" {
+		return Accept{}, false
+	}
+
+	accept := Accept{Value: value, Q: 1, Params: map[string]string{}}
+	for _, field := range fields[1:] {
+		key, paramValue, _ := strings.Cut(strings.TrimSpace(field), "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+		paramValue = strings.Trim(strings.TrimSpace(paramValue), `"`)
+		if key == "q" {
+			q, err := strconv.ParseFloat(paramValue, 64)
+			if err != nil || q < 0 || q > 1 {
+				return Accept{}, false
+			}
+			accept.Q = q
+			// anything after q is an accept-ext, not a media type parameter
+			break
+		}
+		if key != "" {
+			accept.Params[key] = paramValue
+		}
+	}
+	return accept, true
+}
+
+// mediaMatch reports how specifically accept matches offer: 0 for no match,
+// 1 for */*, 2 for type/*, 3 for an exact type and 4 for an exact type
+// whose parameters also match.
+func mediaMatch(accept Accept, offer string) int {
+	parsed, ok := parseElement(offer)
+	if !ok {
+		return 0
+	}
+	if accept.Value == "*/*" {
+		return 1
+	}
+	acceptMain, acceptSub, _ := strings.Cut(accept.Value, "/")
+	offerMain, offerSub, _ := strings.Cut(parsed.Value, "/")
+	if acceptMain != offerMain {
+		return 0
+	}
+	if acceptSub == "*" {
+		return 2
+	}
+	if acceptSub != offerSub {
+		return 0
+	}
+	if len(accept.Params) == 0 {
+		return 3
+	}
+	for key, value := range accept.Params {
+		if offerValue, ok := parsed.Params[key]; !ok || offerValue != value {
+			return 0
+		}
+	}
+	return 4
+}
+
+// languageMatch implements RFC 4647 basic filtering: "en" matches "en" and
+// "en-GB", "*" matches anything.
+func languageMatch(accept Accept, offer string) int {
+	offer = strings.ToLower(offer)
+	switch {
+	case accept.Value == "*":
+		return 1
+	case accept.Value == offer:
+		return 3
+	case strings.HasPrefix(offer, accept.Value+"-"):
+		return 2
+	}
+	return 0
+}
+
+func tokenMatch(accept Accept, offer string) int {
+	switch {
+	case accept.Value == "*":
+		return 1
+	case accept.Value == strings.ToLower(offer):
+		return 2
+	}
+	return 0
+}
+
+// negotiate returns the offer with the highest q-value, taking each
+// offer's q from its most specific matching element. Ties go to the
+// earlier offer.
+func negotiate(accepts []Accept, offers []string, match func(Accept, string) int) (string, bool) {
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		q, specificity := 0.0, 0
+		for _, accept := range accepts {
+			if s := match(accept, offer); s > specificity {
+				q, specificity = accept.Q, s
+			}
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best, bestQ > 0
+}
+
+func notAcceptable(header string) error {
+	return &StatusError{StatusCode: 406, Message: "none of the available representations match " + header}
+}
+
+// Negotiate picks the media type from offers that best matches the
+// request's Accept header. Without an Accept header the first offer is
+// returned. If nothing is acceptable the error is a 406 StatusError.
+func Negotiate(r *Request, offers []string) (string, error) {
+	header := r.Headers.Get("accept")
+	if header == "" && len(offers) > 0 {
+		return offers[0], nil
+	}
+	if best, ok := negotiate(ParseAccept(header), offers, mediaMatch); ok {
+		return best, nil
+	}
+	return "", notAcceptable("Accept: " + header)
+}
+
+// NegotiateLanguage is Negotiate for Accept-Language and language tags.
+func NegotiateLanguage(r *Request, offers []string) (string, error) {
+	header := r.Headers.Get("accept-language")
+	if header == "" && len(offers) > 0 {
+		return offers[0], nil
+	}
+	if best, ok := negotiate(ParseAccept(header), offers, languageMatch); ok {
+		return best, nil
+	}
+	return "", notAcceptable("Accept-Language: " + header)
+}
+
+// NegotiateCharset is Negotiate for Accept-Charset and charset names.
+func NegotiateCharset(r *Request, offers []string) (string, error) {
+	header := r.Headers.Get("accept-charset")
+	if header == "" && len(offers) > 0 {
+		return offers[0], nil
+	}
+	if best, ok := negotiate(ParseAccept(header), offers, tokenMatch); ok {
+		return best, nil
+	}
+	return "", notAcceptable("Accept-Charset: " + header)
+}
+
+// NegotiateToken picks from offers using an arbitrary token-valued
+// Accept-style header such as Accept-Encoding. It returns "" if nothing is
+// acceptable.
+func NegotiateToken(header string, offers []string) string {
+	best, _ := negotiate(ParseAccept(header), offers, tokenMatch)
+	return best
+}
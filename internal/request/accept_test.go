@@ -0,0 +1,71 @@
+package request
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func acceptRequest(t *testing.T, rawHeaders string) *Request {
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\n" + rawHeaders + "\r\n"))
+	require.NoError(t, err)
+	return r
+}
+
+func TestParseAccept(t *testing.T) {
+	// Test: ordering by q with media type parameters
+	accepts := ParseAccept("text/html;level=1, application/json;q=0.9, */*;q=0.1, text/plain;q=bogus")
+	require.Len(t, accepts, 3)
+	assert.Equal(t, "text/html", accepts[0].Value)
+	assert.Equal(t, map[string]string{"level": "1"}, accepts[0].Params)
+	assert.Equal(t, "application/json", accepts[1].Value)
+	assert.Equal(t, 0.9, accepts[1].Q)
+	assert.Equal(t, "*/*", accepts[2].Value)
+}
+
+func TestNegotiate(t *testing.T) {
+	offers := []string{"text/html", "application/json", "text/plain"}
+
+	// Test: no Accept header takes the first offer
+	best, err := Negotiate(acceptRequest(t, ""), offers)
+	require.NoError(t, err)
+	assert.Equal(t, "text/html", best)
+
+	// Test: highest q wins
+	best, err = Negotiate(acceptRequest(t, "Accept: text/html;q=0.5, application/json\r\n"), offers)
+	require.NoError(t, err)
+	assert.Equal(t, "application/json", best)
+
+	// Test: the most specific match decides an offer's q
+	best, err = Negotiate(acceptRequest(t, "Accept: text/*, text/html;q=0\r\n"), offers)
+	require.NoError(t, err)
+	assert.Equal(t, "text/plain", best)
+
+	// Test: nothing acceptable is a 406
+	var statusErr *StatusError
+	_, err = Negotiate(acceptRequest(t, "Accept: image/png\r\n"), offers)
+	require.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, 406, statusErr.StatusCode)
+
+	// Test: parameters match whole names and values, names in any case
+	params := []string{"text/html;level=10", "text/html;xlevel=1", "text/html; Level=1"}
+	best, err = Negotiate(acceptRequest(t, "Accept: text/html;level=1, */*;q=0.1\r\n"), params)
+	require.NoError(t, err)
+	assert.Equal(t, "text/html; Level=1", best)
+	_, err = Negotiate(acceptRequest(t, "Accept: text/html;level=1\r\n"), params[:2])
+	require.ErrorAs(t, err, &statusErr)
+
+	// Test: language prefixes
+	best, err = NegotiateLanguage(acceptRequest(t, "Accept-Language: fr;q=0.8, en\r\n"), []string{"fr-CA", "en-GB"})
+	require.NoError(t, err)
+	assert.Equal(t, "en-GB", best)
+	_, err = NegotiateLanguage(acceptRequest(t, "Accept-Language: de\r\n"), []string{"fr-CA", "en-GB"})
+	require.ErrorAs(t, err, &statusErr)
+
+	// Test: charsets are case insensitive
+	best, err = NegotiateCharset(acceptRequest(t, "Accept-Charset: iso-8859-1;q=0.5, UTF-8\r\n"), []string{"ISO-8859-1", "utf-8"})
+	require.NoError(t, err)
+	assert.Equal(t, "utf-8", best)
+}
@@ -0,0 +1,78 @@
+package request
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// DefaultMaxDecodedSize is a reasonable limit for DecodeContentEncoding.
+const DefaultMaxDecodedSize int64 = 10 << 20 // 10 MB
+
+// DecodeContentEncoding replaces a gzip or deflate compressed Body with its
+// decoded contents, refusing to inflate it past maxSize bytes so a small
+// upload can't expand into gigabytes. On success the Content-Encoding
+// header is removed, Content-Length is updated to the decoded length and
+// WireLength keeps the length that was actually received.
+func (r *Request) DecodeContentEncoding(maxSize int64) error {
+	header := r.Headers.Get("content-encoding")
+	if header == "" {
+		return nil
+	}
+
+	// encodings are listed in the order they were applied, so undo them
+	// from last to first
+	encodings := strings.Split(header, ",")
+	body := r.Body
+	for i := len(encodings) - 1; i >= 0; i-- {
+		encoding := strings.ToLower(strings.TrimSpace(encodings[i]))
+		decoded, err := decodeBody(encoding, body, maxSize)
+		if err != nil {
+			return err
+		}
+		body = decoded
+	}
+
+	r.WireLength = len(r.Body)
+	r.Body = body
+	r.Headers.Delete("content-encoding")
+	r.Headers["content-length"] = strconv.Itoa(len(body))
+	return nil
+}
+
+func decodeBody(encoding string, body []byte, maxSize int64) ([]byte, error) {
+	var reader io.Reader
+	var err error
+	switch encoding {
+	case "identity", "":
+		return body, nil
+	case "gzip", "x-gzip":
+		reader, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		// "deflate" should be zlib wrapped, but plenty of clients send raw
+		// deflate data, so accept both
+		reader, err = zlib.NewReader(bytes.NewReader(body))
+		if err != nil {
+			reader, err = flate.NewReader(bytes.NewReader(body)), nil
+		}
+	default:
+		return nil, &StatusError{StatusCode: 415, Message: "unsupported Content-Encoding: " + encoding}
+	}
+	if err != nil {
+		return nil, &StatusError{StatusCode: 400, Message: "invalid " + encoding + " body: " + err.Error()}
+	}
+
+	// read one byte past the limit to tell "exactly maxSize" from "more"
+	decoded, err := io.ReadAll(io.LimitReader(reader, maxSize+1))
+	if err != nil {
+		return nil, &StatusError{StatusCode: 400, Message: "invalid " + encoding + " body: " + err.Error()}
+	}
+	if int64(len(decoded)) > maxSize {
+		return nil, &StatusError{StatusCode: 413, Message: "decoded body exceeds " + strconv.FormatInt(maxSize, 10) + " bytes"}
+	}
+	return decoded, nil
+}
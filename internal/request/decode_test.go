@@ -0,0 +1,83 @@
+package request
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encodedRequest(t *testing.T, encoding string, body []byte) *Request {
+	r, err := RequestFromReader(strings.NewReader("POST /upload HTTP/1.1\r\n" +
+		"Content-Encoding: " + encoding + "\r\n" +
+		"Content-Length: " + strconv.Itoa(len(body)) + "\r\n" +
+		"\r\n" +
+		string(body)))
+	require.NoError(t, err)
+	return r
+}
+
+func gzipBytes(data []byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(data)
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestDecodeContentEncoding(t *testing.T) {
+	plain := []byte(strings.Repeat("hello decoding ", 50))
+
+	// Test: gzip body
+	compressed := gzipBytes(plain)
+	r := encodedRequest(t, "gzip", compressed)
+	require.NoError(t, r.DecodeContentEncoding(DefaultMaxDecodedSize))
+	assert.Equal(t, plain, r.Body)
+	assert.Equal(t, len(compressed), r.WireLength)
+	assert.Equal(t, strconv.Itoa(len(plain)), r.Headers.Get("content-length"))
+	assert.Equal(t, "", r.Headers.Get("content-encoding"))
+
+	// Test: zlib and raw deflate bodies
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(plain)
+	zw.Close()
+	r = encodedRequest(t, "deflate", buf.Bytes())
+	require.NoError(t, r.DecodeContentEncoding(DefaultMaxDecodedSize))
+	assert.Equal(t, plain, r.Body)
+
+	buf.Reset()
+	fw, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+	fw.Write(plain)
+	fw.Close()
+	r = encodedRequest(t, "deflate", buf.Bytes())
+	require.NoError(t, r.DecodeContentEncoding(DefaultMaxDecodedSize))
+	assert.Equal(t, plain, r.Body)
+
+	// Test: stacked encodings are undone in reverse order
+	r = encodedRequest(t, "gzip, gzip", gzipBytes(gzipBytes(plain)))
+	require.NoError(t, r.DecodeContentEncoding(DefaultMaxDecodedSize))
+	assert.Equal(t, plain, r.Body)
+
+	// Test: bodies that inflate past the limit are refused
+	var statusErr *StatusError
+	bomb := gzipBytes(make([]byte, 1<<20))
+	r = encodedRequest(t, "gzip", bomb)
+	require.ErrorAs(t, r.DecodeContentEncoding(1024), &statusErr)
+	assert.Equal(t, 413, statusErr.StatusCode)
+	assert.Equal(t, bomb, r.Body)
+
+	// Test: unknown encodings and corrupt data
+	r = encodedRequest(t, "br", []byte("whatever"))
+	require.ErrorAs(t, r.DecodeContentEncoding(DefaultMaxDecodedSize), &statusErr)
+	assert.Equal(t, 415, statusErr.StatusCode)
+	r = encodedRequest(t, "gzip", []byte("not gzip"))
+	require.ErrorAs(t, r.DecodeContentEncoding(DefaultMaxDecodedSize), &statusErr)
+	assert.Equal(t, 400, statusErr.StatusCode)
+}
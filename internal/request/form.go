@@ -0,0 +1,178 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"mime"
+	"mime/multipart"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// MaxFormSize caps the size of a request body that ParseForm and
+// ParseMultipartForm are willing to parse.
+var MaxFormSize int64 = 10 << 20 // 10 MB
+
+// DefaultMaxMemory is the number of bytes of multipart file parts kept in
+// memory before the rest is spooled to temporary files on disk.
+const DefaultMaxMemory int64 = 32 << 20 // 32 MB
+
+var ErrNotMultipart = errors.New("request Content-Type isn't multipart/form-data")
+
+// Path returns the request target without its query string. For an
+// absolute-form target it's the path part of the URL.
+func (r *Request) Path() string {
+	path, _, _ := strings.Cut(r.OriginTarget(), "?")
+	return path
+}
+
+// Query parses the query string of the request target.
+func (r *Request) Query() url.Values {
+	_, rawQuery, _ := strings.Cut(r.OriginTarget(), "?")
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return url.Values{}
+	}
+	return values
+}
+
+// OriginTarget is the request target as if it were in origin-form, the
+// path and query of an absolute-form target.
+func (r *Request) OriginTarget() string {
+	target := r.RequestLine.RequestTarget
+	if r.RequestLine.TargetForm != AbsoluteForm {
+		return target
+	}
+	_, rest, _ := strings.Cut(target, "://")
+	if i := strings.IndexAny(rest, "/?"); i >= 0 {
+		if rest[i] == '?' {
+			return "/" + rest[i:]
+		}
+		return rest[i:]
+	}
+	return "/"
+}
+
+func (r *Request) mediaType() (string, map[string]string, error) {
+	contentType := r.Headers.Get("content-type")
+	if contentType == "" {
+		return "", nil, nil
+	}
+	return mime.ParseMediaType(contentType)
+}
+
+// ParseForm populates r.PostForm from an application/x-www-form-urlencoded
+// body and r.Form from both the body and the query string. Body values are
+// listed before query values for the same key. It is safe to call more than
+// once.
+func (r *Request) ParseForm() error {
+	if r.Form != nil {
+		return nil
+	}
+
+	if int64(len(r.Body)) > MaxFormSize {
+		return errors.New("form body too large: " + strconv.Itoa(len(r.Body)) + " bytes")
+	}
+
+	r.PostForm = url.Values{}
+	mediaType, _, err := r.mediaType()
+	if err != nil {
+		return errors.New("invalid content-type header: " + r.Headers.Get("content-type"))
+	}
+
+	if mediaType == "application/x-www-form-urlencoded" {
+		values, err := url.ParseQuery(string(r.Body))
+		if err != nil {
+			return err
+		}
+		r.PostForm = values
+	}
+
+	r.Form = url.Values{}
+	for key, values := range r.PostForm {
+		r.Form[key] = append(r.Form[key], values...)
+	}
+	for key, values := range r.Query() {
+		r.Form[key] = append(r.Form[key], values...)
+	}
+	return nil
+}
+
+// MultipartReader returns a reader over the parts of a multipart/form-data
+// body so handlers can stream through the parts themselves instead of
+// calling ParseMultipartForm.
+func (r *Request) MultipartReader() (*multipart.Reader, error) {
+	mediaType, params, err := r.mediaType()
+	if err != nil || mediaType != "multipart/form-data" {
+		return nil, ErrNotMultipart
+	}
+	boundary := params["boundary"]
+	if boundary == "" {
+		return nil, errors.New("multipart/form-data without a boundary")
+	}
+	return multipart.NewReader(bytes.NewReader(r.Body), boundary), nil
+}
+
+// ParseMultipartForm parses a multipart/form-data body. Up to maxMemory bytes
+// of file parts are kept in memory, the rest is stored in temporary files.
+// Non-file values are merged into r.Form and r.PostForm alongside the query
+// string.
+func (r *Request) ParseMultipartForm(maxMemory int64) error {
+	if r.MultipartForm != nil {
+		return nil
+	}
+
+	if int64(len(r.Body)) > MaxFormSize {
+		return errors.New("form body too large: " + strconv.Itoa(len(r.Body)) + " bytes")
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return err
+	}
+
+	form, err := reader.ReadForm(maxMemory)
+	if err != nil {
+		return err
+	}
+
+	for key, values := range form.Value {
+		r.PostForm[key] = append(r.PostForm[key], values...)
+		// keep body values ahead of query values
+		r.Form[key] = append(append([]string{}, values...), r.Form[key]...)
+	}
+	r.MultipartForm = form
+	return nil
+}
+
+// FormValue returns the first value for key from the query string or a
+// urlencoded or multipart body.
+func (r *Request) FormValue(key string) string {
+	if r.MultipartForm == nil {
+		r.ParseMultipartForm(DefaultMaxMemory)
+	}
+	return r.Form.Get(key)
+}
+
+// FormFile returns the first file uploaded under key in a multipart body.
+func (r *Request) FormFile(key string) (multipart.File, *multipart.FileHeader, error) {
+	if r.MultipartForm == nil {
+		if err := r.ParseMultipartForm(DefaultMaxMemory); err != nil {
+			return nil, nil, err
+		}
+	}
+	files := r.MultipartForm.File[key]
+	if len(files) == 0 {
+		return nil, nil, errors.New("no file uploaded for form key: " + key)
+	}
+	f, err := files[0].Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, files[0], nil
+}
@@ -0,0 +1,93 @@
+package request
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseForm(t *testing.T) {
+	// Test: urlencoded body merged with query string
+	r, err := RequestFromReader(strings.NewReader("POST /submit?name=query&page=2 HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Type: application/x-www-form-urlencoded\r\n" +
+		"Content-Length: 23\r\n" +
+		"\r\n" +
+		"name=body&color=green+1"))
+	require.NoError(t, err)
+	require.NoError(t, r.ParseForm())
+	assert.Equal(t, []string{"body", "query"}, r.Form["name"])
+	assert.Equal(t, "green 1", r.Form.Get("color"))
+	assert.Equal(t, "2", r.Form.Get("page"))
+	assert.Equal(t, "", r.PostForm.Get("page"))
+	assert.Equal(t, "/submit", r.Path())
+
+	// Test: non-form body is ignored
+	r, err = RequestFromReader(strings.NewReader("POST /submit?a=1 HTTP/1.1\r\n" +
+		"Content-Type: application/json\r\n" +
+		"Content-Length: 2\r\n" +
+		"\r\n" +
+		"{}"))
+	require.NoError(t, err)
+	require.NoError(t, r.ParseForm())
+	assert.Equal(t, "1", r.Form.Get("a"))
+	assert.Empty(t, r.PostForm)
+
+	// Test: body over the size limit
+	old := MaxFormSize
+	MaxFormSize = 4
+	defer func() { MaxFormSize = old }()
+	r, err = RequestFromReader(strings.NewReader("POST /submit HTTP/1.1\r\n" +
+		"Content-Type: application/x-www-form-urlencoded\r\n" +
+		"Content-Length: 7\r\n" +
+		"\r\n" +
+		"a=12345"))
+	require.NoError(t, err)
+	require.Error(t, r.ParseForm())
+}
+
+func TestParseMultipartForm(t *testing.T) {
+	body := "--xyz\r\n" +
+		"Content-Disposition: form-data; name=\"title\"\r\n" +
+		"\r\n" +
+		"hello\r\n" +
+		"--xyz\r\n" +
+		"Content-Disposition: form-data; name=\"upload\"; filename=\"notes.txt\"\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"file contents\r\n" +
+		"--xyz--\r\n"
+	r, err := RequestFromReader(strings.NewReader("POST /upload?title=query HTTP/1.1\r\n" +
+		"Content-Type: multipart/form-data; boundary=xyz\r\n" +
+		"Content-Length: " + strconv.Itoa(len(body)) + "\r\n" +
+		"\r\n" +
+		body))
+	require.NoError(t, err)
+
+	// Test: file spooled to disk when over the memory threshold
+	require.NoError(t, r.ParseMultipartForm(1))
+	defer r.MultipartForm.RemoveAll()
+	assert.Equal(t, []string{"hello", "query"}, r.Form["title"])
+	assert.Equal(t, "hello", r.FormValue("title"))
+
+	f, header, err := r.FormFile("upload")
+	require.NoError(t, err)
+	defer f.Close()
+	assert.Equal(t, "notes.txt", header.Filename)
+	contents, err := io.ReadAll(f)
+	require.NoError(t, err)
+	assert.Equal(t, "file contents", string(contents))
+
+	// Test: missing file
+	_, _, err = r.FormFile("missing")
+	require.Error(t, err)
+
+	// Test: not multipart
+	r, err = RequestFromReader(strings.NewReader("POST /upload HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	require.ErrorIs(t, r.ParseMultipartForm(DefaultMaxMemory), ErrNotMultipart)
+}
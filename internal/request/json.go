@@ -0,0 +1,72 @@
+package request
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// MaxJSONSize caps the size of a request body that DecodeJSON will decode.
+var MaxJSONSize int64 = 1 << 20 // 1 MB
+
+// StatusError is returned by the body decoding helpers so callers can
+// respond with the status code that matches the failure.
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	return e.Message
+}
+
+// DecodeJSON decodes a JSON request body into v. The request must have a
+// JSON Content-Type and a body no larger than MaxJSONSize, and must contain
+// exactly one JSON value.
+func DecodeJSON(r *Request, v any) error {
+	return decodeJSON(r, v, false)
+}
+
+// DecodeJSONStrict is like DecodeJSON but also rejects object keys that
+// don't match a field in v.
+func DecodeJSONStrict(r *Request, v any) error {
+	return decodeJSON(r, v, true)
+}
+
+func isJSONMediaType(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+func decodeJSON(r *Request, v any, strict bool) error {
+	mediaType, _, err := r.mediaType()
+	if err != nil || !isJSONMediaType(mediaType) {
+		return &StatusError{StatusCode: 415, Message: "expected Content-Type application/json, got: " + r.Headers.Get("content-type")}
+	}
+
+	if int64(len(r.Body)) > MaxJSONSize {
+		return &StatusError{StatusCode: 400, Message: "JSON body too large: " + strconv.Itoa(len(r.Body)) + " bytes"}
+	}
+
+	if len(r.Body) == 0 {
+		return &StatusError{StatusCode: 400, Message: "empty JSON body"}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(r.Body))
+	if strict {
+		decoder.DisallowUnknownFields()
+	}
+
+	if err := decoder.Decode(v); err != nil {
+		return &StatusError{StatusCode: 400, Message: "invalid JSON body: " + err.Error()}
+	}
+
+	// anything after the first value is an error, not a second request
+	if _, err := decoder.Token(); !errors.Is(err, io.EOF) {
+		return &StatusError{StatusCode: 400, Message: "invalid JSON body: unexpected data after top-level value"}
+	}
+
+	return nil
+}
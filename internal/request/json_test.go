@@ -0,0 +1,56 @@
+package request
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func jsonRequest(t *testing.T, contentType, body string) *Request {
+	r, err := RequestFromReader(strings.NewReader("POST /api HTTP/1.1\r\n" +
+		"Content-Type: " + contentType + "\r\n" +
+		"Content-Length: " + strconv.Itoa(len(body)) + "\r\n" +
+		"\r\n" +
+		body))
+	require.NoError(t, err)
+	return r
+}
+
+func TestDecodeJSON(t *testing.T) {
+	type payload struct {
+		Name string `json:"name"`
+	}
+
+	// Test: valid body
+	var p payload
+	r := jsonRequest(t, "application/json; charset=utf-8", `{"name":"gopher","extra":1}`)
+	require.NoError(t, DecodeJSON(r, &p))
+	assert.Equal(t, "gopher", p.Name)
+
+	// Test: unknown fields rejected in strict mode
+	var statusErr *StatusError
+	err := DecodeJSONStrict(r, &p)
+	require.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, 400, statusErr.StatusCode)
+
+	// Test: wrong content type
+	r = jsonRequest(t, "text/plain", `{"name":"gopher"}`)
+	require.ErrorAs(t, DecodeJSON(r, &p), &statusErr)
+	assert.Equal(t, 415, statusErr.StatusCode)
+
+	// Test: trailing data
+	r = jsonRequest(t, "application/problem+json", `{"name":"a"} {"name":"b"}`)
+	require.ErrorAs(t, DecodeJSON(r, &p), &statusErr)
+	assert.Equal(t, 400, statusErr.StatusCode)
+
+	// Test: body too large
+	old := MaxJSONSize
+	MaxJSONSize = 4
+	defer func() { MaxJSONSize = old }()
+	r = jsonRequest(t, "application/json", `{"name":"gopher"}`)
+	require.ErrorAs(t, DecodeJSON(r, &p), &statusErr)
+	assert.Equal(t, 400, statusErr.StatusCode)
+}
@@ -1,12 +1,18 @@
 package request
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
+	"mime/multipart"
+	"net"
+	"net/url"
 	"strconv"
 	"strings"
 
+	"github.com/jsleep/httpfromtcp/internal/cookie"
 	"github.com/jsleep/httpfromtcp/internal/headers"
 )
 
@@ -22,17 +28,104 @@ type Request struct {
 	State       int
 	Headers     headers.Headers
 	Body        []byte
+
+	// RemoteAddr is the address of the client that sent the request, as
+	// "host:port". The server sets it.
+	RemoteAddr string
+
+	// TLS describes the connection the request arrived on when it was
+	// served over TLS: version, negotiated ALPN protocol, peer certificates.
+	// It's nil for plaintext connections.
+	TLS *tls.ConnectionState
+
+	// WireLength is the body length as received. It's only set once
+	// DecodeContentEncoding has replaced Body with the decoded contents.
+	WireLength int
+
+	// Form, PostForm and MultipartForm are only populated after
+	// ParseForm or ParseMultipartForm is called.
+	Form          url.Values
+	PostForm      url.Values
+	MultipartForm *multipart.Form
+
+	// Trailers are sent after a chunked body by WriteTo.
+	Trailers headers.Headers
+
+	// headerOrder is the order header names arrived in, so WriteTo can
+	// send them the same way.
+	headerOrder []string
+
+	ctx context.Context
 }
 
 type RequestLine struct {
 	HttpVersion   string
 	RequestTarget string
 	Method        string
+
+	// TargetForm is how RequestTarget is written. Authority is the
+	// "host[:port]" it names in absolute-form and authority-form.
+	TargetForm TargetForm
+	Authority  string
 }
 
+// TargetForm is one of the ways a request target can be written (RFC 9112
+// section 3.2).
+type TargetForm int
+
+const (
+	// OriginForm is a path and query, "/where?q=1", the usual form.
+	OriginForm TargetForm = iota
+	// AbsoluteForm is a whole URL, "http://example.com/where", as sent to
+	// a forward proxy.
+	AbsoluteForm
+	// AuthorityForm is just "host:port", only used by CONNECT.
+	AuthorityForm
+	// AsteriskForm is "*", only used by OPTIONS for the whole server.
+	AsteriskForm
+)
+
 const BufferSize = 8
 
+// NewRequest returns a request for a client to send: method to target, an
+// absolute URL like "http://example.com/where?q=1", with body.
+func NewRequest(method string, target string, body []byte) (*Request, error) {
+	if !alphaOnly(method) || method == "" {
+		return nil, errors.New("expected method to be alphanumeric, got: " + method)
+	}
+	form, authority, err := parseRequestTarget(method, target)
+	if err != nil {
+		return nil, err
+	}
+	if form != AbsoluteForm {
+		return nil, errors.New("expected request target to be an absolute URL, got: " + target)
+	}
+	if body == nil {
+		body = []byte{}
+	}
+	return &Request{
+		RequestLine: RequestLine{
+			Method:        method,
+			RequestTarget: target,
+			HttpVersion:   "1.1",
+			TargetForm:    form,
+			Authority:     authority,
+		},
+		State:   done,
+		Headers: headers.NewHeaders(),
+		Body:    body,
+	}, nil
+}
+
 func RequestFromReader(reader io.Reader) (*Request, error) {
+	req, _, err := ReadRequest(reader)
+	return req, err
+}
+
+// ReadRequest is RequestFromReader that also returns the bytes it read past
+// the end of the request, e.g. the start of a pipelined request or of
+// whatever protocol the connection switches to.
+func ReadRequest(reader io.Reader) (*Request, []byte, error) {
 
 	requestParser := Request{Headers: headers.NewHeaders(), State: initialized, Body: make([]byte, 0)}
 	buffer := make([]byte, BufferSize)
@@ -45,7 +138,7 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 
 		if hitEOF && last_parsed == 0 {
 			// if we hit EOF and nothing was parsed, we can't continue
-			return nil, errors.New("EOF and can't parse further")
+			return nil, nil, errors.New("EOF and can't parse further")
 		}
 
 		if bytes_read == len(buffer) {
@@ -63,7 +156,7 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 					fmt.Println("Hit EOF")
 					hitEOF = true
 				} else {
-					return nil, err
+					return nil, nil, err
 				}
 			}
 			bytes_read += n
@@ -78,7 +171,7 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 		for n != 0 && requestParser.State != done {
 			n, err = requestParser.parse(buffer[bytes_parsed:bytes_read])
 			if err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 			last_parsed = n
 			bytes_parsed += n
@@ -88,7 +181,7 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 
 	fmt.Println("Request state:", requestParser.State)
 
-	return &requestParser, nil
+	return &requestParser, buffer[bytes_parsed:bytes_read], nil
 }
 
 const alpha = "abcdefghijklmnopqrstuvwxyz"
@@ -125,15 +218,49 @@ func parseRequestLine(requestString string) (*RequestLine, int, error) {
 		return nil, 0, errors.New("expected HTTP version to be HTTP/1.1, got: " + httpVersion)
 	}
 
+	form, authority, err := parseRequestTarget(method, requestTarget)
+	if err != nil {
+		return nil, 0, err
+	}
+
 	res := RequestLine{
 		Method:        method,
 		RequestTarget: requestTarget,
 		HttpVersion:   strings.Split(httpVersion, "/")[1],
+		TargetForm:    form,
+		Authority:     authority,
 	}
 
 	return &res, len(line) + 2, nil
 }
 
+// parseRequestTarget works out which form target is in, and the authority
+// it names if any.
+func parseRequestTarget(method string, target string) (TargetForm, string, error) {
+	if method == "CONNECT" {
+		host, port, err := net.SplitHostPort(target)
+		if err != nil || host == "" {
+			return 0, "", errors.New("expected CONNECT target to be host:port, got: " + target)
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return 0, "", errors.New("expected CONNECT target port to be 1-65535, got: " + port)
+		}
+		return AuthorityForm, target, nil
+	}
+
+	if target == "*" {
+		return AsteriskForm, "", nil
+	}
+	if strings.HasPrefix(target, "/") {
+		return OriginForm, "", nil
+	}
+	u, err := url.Parse(target)
+	if err == nil && u.Scheme != "" && u.Host != "" {
+		return AbsoluteForm, u.Host, nil
+	}
+	return 0, "", errors.New("expected request target to be a path, an absolute URL or *, got: " + target)
+}
+
 func (r *Request) parse(data []byte) (int, error) {
 	if r.State == done {
 		return 0, errors.New("request already parsed")
@@ -157,6 +284,9 @@ func (r *Request) parse(data []byte) (int, error) {
 		if err != nil {
 			return 0, err
 		}
+		if bytes > 0 {
+			r.recordHeaderName(string(data[:bytes]))
+		}
 
 		if finished {
 			bytes += 2 // account for the \r\n after headers
@@ -181,6 +311,19 @@ func (r *Request) parse(data []byte) (int, error) {
 	}
 }
 
+// recordHeaderName notes the name of a header line the first time it's
+// seen.
+func (r *Request) recordHeaderName(line string) {
+	name, _, _ := strings.Cut(line, ":")
+	name = strings.ToLower(strings.TrimSpace(name))
+	for _, seen := range r.headerOrder {
+		if seen == name {
+			return
+		}
+	}
+	r.headerOrder = append(r.headerOrder, name)
+}
+
 func (r *Request) parseBody(data []byte) (int, error) {
 	length, err := strconv.Atoi(r.Headers.Get("content-length"))
 	if err != nil {
@@ -218,3 +361,36 @@ func (req Request) Print() {
 		fmt.Println("(no body)")
 	}
 }
+
+// Cookies parses the request's Cookie header into a name to value map.
+func (r *Request) Cookies() map[string]string {
+	return cookie.Parse(r.Headers.Get("cookie"))
+}
+
+// Cookie returns the value of the named cookie and whether it was sent.
+func (r *Request) Cookie(name string) (string, bool) {
+	value, ok := r.Cookies()[name]
+	return value, ok
+}
+
+// Context returns the request's context. The server cancels it when the
+// client disconnects, when the server is closed and once the handler
+// returns. It's never nil.
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// WithContext returns a shallow copy of r with its context changed to ctx,
+// for middleware to add values or a deadline before calling the next
+// handler.
+func (r *Request) WithContext(ctx context.Context) *Request {
+	if ctx == nil {
+		panic("nil context")
+	}
+	r2 := *r
+	r2.ctx = ctx
+	return &r2
+}
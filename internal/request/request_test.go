@@ -215,3 +215,50 @@ func TestBodyParse(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.Error(t, err)
 }
+
+func TestReadRequestLeftover(t *testing.T) {
+	// Test: bytes read past the end of the request are handed back
+	reader := &chunkReader{
+		data:            "POST /submit HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 5\r\n\r\nhelloGET /next HTTP/1.1\r\n\r\n",
+		numBytesPerRead: 7,
+	}
+	r, leftover, err := ReadRequest(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(r.Body))
+	rest, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "GET /next HTTP/1.1\r\n\r\n", string(leftover)+string(rest))
+}
+
+func TestRequestTargetForms(t *testing.T) {
+	// Test: absolute-form, with the authority and the path split out
+	r, err := RequestFromReader(strings.NewReader("GET http://example.com:8080/coffee?size=large HTTP/1.1\r\nHost: example.com:8080\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, AbsoluteForm, r.RequestLine.TargetForm)
+	assert.Equal(t, "example.com:8080", r.RequestLine.Authority)
+	assert.Equal(t, "/coffee", r.Path())
+	assert.Equal(t, "large", r.Query().Get("size"))
+
+	// Test: authority-form for CONNECT
+	r, err = RequestFromReader(strings.NewReader("CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, AuthorityForm, r.RequestLine.TargetForm)
+	assert.Equal(t, "example.com:443", r.RequestLine.Authority)
+
+	// Test: origin-form and asterisk-form
+	r, err = RequestFromReader(strings.NewReader("OPTIONS * HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, AsteriskForm, r.RequestLine.TargetForm)
+	r, err = RequestFromReader(strings.NewReader("GET /coffee HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, OriginForm, r.RequestLine.TargetForm)
+	assert.Empty(t, r.RequestLine.Authority)
+
+	// Test: CONNECT needs a host and port, and other methods a usable target
+	_, err = RequestFromReader(strings.NewReader("CONNECT example.com HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	require.Error(t, err)
+	_, err = RequestFromReader(strings.NewReader("CONNECT /coffee HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.Error(t, err)
+	_, err = RequestFromReader(strings.NewReader("GET coffee HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.Error(t, err)
+}
@@ -0,0 +1,126 @@
+package request
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jsleep/httpfromtcp/internal/headers"
+)
+
+// WriteTo sends r on w as it would go over the wire. Headers that were
+// parsed go out in the order they arrived, followed by any added since in
+// name order. The body is framed to match: chunked, then trailers, when
+// there's a Transfer-Encoding or there are Trailers, otherwise with a
+// Content-Length. Other transfer codings are kept in the order given, with
+// chunked moved to the end, since a request's body can only be delimited
+// by chunked coming last. Framing headers already set are replaced.
+func (r *Request) WriteTo(w io.Writer) (int64, error) {
+	var b bytes.Buffer
+
+	version := r.RequestLine.HttpVersion
+	if version == "" {
+		version = "1.1"
+	}
+	b.WriteString(r.RequestLine.Method + " " + r.RequestLine.RequestTarget + " HTTP/" + version + "\r\n")
+
+	if r.Headers.Get("host") == "" && r.RequestLine.Authority != "" {
+		b.WriteString("Host: " + r.RequestLine.Authority + "\r\n")
+	}
+	for _, name := range r.orderedHeaderNames() {
+		switch strings.ToLower(name) {
+		case "content-length", "transfer-encoding", "trailer":
+			continue
+		}
+		b.WriteString(headers.Canonical(name) + ": " + r.Headers[name] + "\r\n")
+	}
+
+	transferEncoding := r.Headers.Get("transfer-encoding")
+	chunked := len(r.Trailers) > 0 || strings.TrimSpace(transferEncoding) != ""
+	if chunked {
+		b.WriteString("Transfer-Encoding: " + chunkedLast(transferEncoding) + "\r\n")
+		if len(r.Trailers) > 0 {
+			names := make([]string, 0, len(r.Trailers))
+			for name := range r.Trailers {
+				names = append(names, headers.Canonical(name))
+			}
+			sort.Strings(names)
+			b.WriteString("Trailer: " + strings.Join(names, ", ") + "\r\n")
+		}
+	} else if len(r.Body) > 0 || r.Headers.Get("content-length") != "" || expectsBody(r.RequestLine.Method) {
+		b.WriteString("Content-Length: " + strconv.Itoa(len(r.Body)) + "\r\n")
+	}
+	b.WriteString("\r\n")
+
+	if !chunked {
+		b.Write(r.Body)
+	} else {
+		if len(r.Body) > 0 {
+			b.WriteString(strconv.FormatInt(int64(len(r.Body)), 16) + "\r\n")
+			b.Write(r.Body)
+			b.WriteString("\r\n")
+		}
+		b.WriteString("0\r\n")
+		for _, name := range sortedNames(r.Trailers) {
+			b.WriteString(headers.Canonical(name) + ": " + r.Trailers[name] + "\r\n")
+		}
+		b.WriteString("\r\n")
+	}
+
+	return b.WriteTo(w)
+}
+
+// orderedHeaderNames lists the keys of r.Headers, parsed ones first in
+// the order they arrived.
+func (r *Request) orderedHeaderNames() []string {
+	names := make([]string, 0, len(r.Headers))
+	written := map[string]bool{}
+	for _, name := range r.headerOrder {
+		if _, ok := r.Headers[name]; ok {
+			names = append(names, name)
+			written[name] = true
+		}
+	}
+	var rest []string
+	for name := range r.Headers {
+		if !written[name] {
+			rest = append(rest, name)
+		}
+	}
+	sort.Slice(rest, func(i, j int) bool {
+		return strings.ToLower(rest[i]) < strings.ToLower(rest[j])
+	})
+	return append(names, rest...)
+}
+
+func sortedNames(h headers.Headers) []string {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// chunkedLast returns the codings listed in transferEncoding with chunked
+// applied once, last: "chunked, gzip" becomes "gzip, chunked".
+func chunkedLast(transferEncoding string) string {
+	var codings []string
+	for _, coding := range strings.Split(transferEncoding, ",") {
+		coding = strings.TrimSpace(coding)
+		if coding == "" || strings.EqualFold(coding, "chunked") {
+			continue
+		}
+		codings = append(codings, coding)
+	}
+	return strings.Join(append(codings, "chunked"), ", ")
+}
+
+// expectsBody reports whether requests with method are expected to carry
+// a body, so an empty one is sent as "Content-Length: 0" rather than
+// left out.
+func expectsBody(method string) bool {
+	return method == "POST" || method == "PUT" || method == "PATCH"
+}
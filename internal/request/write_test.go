@@ -0,0 +1,88 @@
+package request
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/jsleep/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteTo(t *testing.T) {
+	raw := "POST /submit?x=1 HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\nX-Zebra: last\r\nContent-Type: text/plain\r\nContent-Length: 5\r\n\r\nhello"
+	r, err := RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+
+	// Test: a parsed request goes back out the way it came in
+	var b bytes.Buffer
+	n, err := r.WriteTo(&b)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(raw)), n)
+	assert.Equal(t, raw, b.String())
+
+	// Test: headers added later follow, in name order, and framing follows the body
+	r.Headers.Set("B-Added", "2")
+	r.Headers.Set("a-added", "1")
+	r.Body = []byte("hi")
+	b.Reset()
+	_, err = r.WriteTo(&b)
+	require.NoError(t, err)
+	assert.Equal(t, "POST /submit?x=1 HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\nX-Zebra: last\r\nContent-Type: text/plain\r\nA-Added: 1\r\nB-Added: 2\r\nContent-Length: 2\r\n\r\nhi", b.String())
+
+	// Test: and it parses again
+	again, err := RequestFromReader(&b)
+	require.NoError(t, err)
+	assert.Equal(t, "hi", string(again.Body))
+	assert.Equal(t, "1", again.Headers.Get("A-Added"))
+}
+
+func TestWriteToFraming(t *testing.T) {
+	// Test: no body, no Content-Length, and a Host from an absolute target
+	r, err := NewRequest("GET", "http://example.com/", nil)
+	require.NoError(t, err)
+	var b bytes.Buffer
+	_, err = r.WriteTo(&b)
+	require.NoError(t, err)
+	assert.Equal(t, "GET http://example.com/ HTTP/1.1\r\nHost: example.com\r\n\r\n", b.String())
+
+	// Test: an empty POST still says so
+	r, err = NewRequest("POST", "http://example.com/", nil)
+	require.NoError(t, err)
+	b.Reset()
+	_, err = r.WriteTo(&b)
+	require.NoError(t, err)
+	assert.Contains(t, b.String(), "Content-Length: 0\r\n")
+
+	// Test: trailers make the body chunked, with the names announced
+	r, err = NewRequest("PUT", "http://example.com/upload", []byte("chunk of data"))
+	require.NoError(t, err)
+	r.Headers.Set("Content-Length", "999")
+	r.Trailers = headers.Headers{"x-checksum": "abc123", "x-count": "1"}
+	b.Reset()
+	_, err = r.WriteTo(&b)
+	require.NoError(t, err)
+	assert.Equal(t, "PUT http://example.com/upload HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\nTrailer: X-Checksum, X-Count\r\n\r\n"+
+		"d\r\nchunk of data\r\n0\r\nX-Checksum: abc123\r\nX-Count: 1\r\n\r\n", b.String())
+
+	// Test: asking for chunked without trailers
+	r, err = NewRequest("POST", "http://example.com/", nil)
+	require.NoError(t, err)
+	r.Headers.Set("Transfer-Encoding", "chunked")
+	b.Reset()
+	_, err = r.WriteTo(&b)
+	require.NoError(t, err)
+	assert.Equal(t, "POST http://example.com/ HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", b.String())
+
+	// Test: other codings are kept, with chunked last
+	for te, want := range map[string]string{"gzip, chunked": "gzip, chunked", "gzip": "gzip, chunked", "chunked, gzip": "gzip, chunked"} {
+		r, err = NewRequest("POST", "http://example.com/", []byte("zz"))
+		require.NoError(t, err)
+		r.Headers.Set("Transfer-Encoding", te)
+		b.Reset()
+		_, err = r.WriteTo(&b)
+		require.NoError(t, err)
+		assert.Equal(t, "POST http://example.com/ HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: "+want+"\r\n\r\n2\r\nzz\r\n0\r\n\r\n", b.String(), te)
+	}
+}
@@ -0,0 +1,153 @@
+package response
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jsleep/httpfromtcp/internal/headers"
+	"github.com/jsleep/httpfromtcp/internal/request"
+)
+
+// StrongETag returns a strong entity tag derived from the body's contents.
+func StrongETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// WeakETag returns a weak entity tag derived from a file's size and
+// modification time, which is cheap to compute without reading the file.
+func WeakETag(size int64, modtime time.Time) string {
+	return `W/"` + strconv.FormatInt(size, 16) + "-" + strconv.FormatInt(modtime.UnixNano(), 16) + `"`
+}
+
+// parseETagList splits an If-Match or If-None-Match value into its entity
+// tags. "*" is returned as a single element.
+func parseETagList(value string) []string {
+	var tags []string
+	value = strings.TrimSpace(value)
+	for value != "" {
+		if value[0] == ',' || value[0] == ' ' || value[0] == '\t' {
+			value = value[1:]
+			continue
+		}
+		if value[0] == '*' {
+			tags = append(tags, "*")
+			value = value[1:]
+			continue
+		}
+		start := 0
+		if strings.HasPrefix(value, "W/") {
+			start = 2
+		}
+		if len(value) <= start || value[start] != '"' {
+			// not an entity tag, give up on the rest of the list
+			return tags
+		}
+		end := strings.IndexByte(value[start+1:], '"')
+		if end < 0 {
+			return tags
+		}
+		end += start + 2
+		tags = append(tags, value[:end])
+		value = value[end:]
+	}
+	return tags
+}
+
+func opaqueTag(tag string) string {
+	return strings.TrimPrefix(tag, "W/")
+}
+
+// etagMatches compares etag against a list header value. Strong comparison
+// requires both tags to be strong, weak comparison only looks at the
+// opaque part.
+func etagMatches(list string, etag string, strong bool) bool {
+	for _, tag := range parseETagList(list) {
+		if tag == "*" {
+			return etag != ""
+		}
+		if etag == "" {
+			continue
+		}
+		if strong {
+			if !strings.HasPrefix(tag, "W/") && !strings.HasPrefix(etag, "W/") && tag == etag {
+				return true
+			}
+		} else if opaqueTag(tag) == opaqueTag(etag) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseHTTPDate(value string) (time.Time, bool) {
+	t, err := time.Parse(httpDateFormat, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// CheckPreconditions evaluates If-Match, If-Unmodified-Since, If-None-Match
+// and If-Modified-Since in the order given by RFC 9110 section 13.2.2
+// against the current representation's etag and modtime (either may be
+// empty). It returns 304 or 412 when the request shouldn't proceed and 0
+// when it should.
+func CheckPreconditions(req *request.Request, etag string, modtime time.Time) StatusCode {
+	method := req.RequestLine.Method
+	modtime = modtime.Truncate(time.Second)
+
+	if ifMatch := req.Headers.Get("if-match"); ifMatch != "" {
+		if !etagMatches(ifMatch, etag, true) {
+			return preconditionFailedCode
+		}
+	} else if since, ok := parseHTTPDate(req.Headers.Get("if-unmodified-since")); ok && !modtime.IsZero() {
+		if modtime.After(since) {
+			return preconditionFailedCode
+		}
+	}
+
+	if ifNoneMatch := req.Headers.Get("if-none-match"); ifNoneMatch != "" {
+		if etagMatches(ifNoneMatch, etag, false) {
+			if method == "GET" || method == "HEAD" {
+				return notModifiedCode
+			}
+			return preconditionFailedCode
+		}
+	} else if since, ok := parseHTTPDate(req.Headers.Get("if-modified-since")); ok && !modtime.IsZero() {
+		if (method == "GET" || method == "HEAD") && !modtime.After(since) {
+			return notModifiedCode
+		}
+	}
+
+	return 0
+}
+
+// notModifiedHeaders are the headers a 304 must repeat from the 200 it
+// stands in for.
+var notModifiedHeaders = []string{"Cache-Control", "Content-Location", "Date", "ETag", "Expires", "Last-Modified", "Vary"}
+
+// WritePreconditionResult writes the 304 or 412 response returned by
+// CheckPreconditions. respHeaders are the headers the full response would
+// have had; a 304 keeps the validator and caching ones and neither response
+// has a body.
+func (w *Writer) WritePreconditionResult(statusCode StatusCode, respHeaders headers.Headers) error {
+	resultHeaders := headers.Headers{"Connection": "close"}
+	if statusCode == notModifiedCode {
+		for _, key := range notModifiedHeaders {
+			if value := respHeaders.Get(key); value != "" {
+				resultHeaders[key] = value
+			}
+		}
+	} else {
+		resultHeaders["Content-Length"] = "0"
+	}
+
+	if err := w.WriteStatusLine(statusCode); err != nil {
+		return err
+	}
+	return w.WriteHeaders(resultHeaders)
+}
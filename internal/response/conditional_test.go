@@ -0,0 +1,72 @@
+package response
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jsleep/httpfromtcp/internal/headers"
+	"github.com/jsleep/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func conditionalRequest(t *testing.T, method string, rawHeaders string) *request.Request {
+	req, err := request.RequestFromReader(strings.NewReader(method + " /file HTTP/1.1\r\n" + rawHeaders + "\r\n"))
+	require.NoError(t, err)
+	return req
+}
+
+func TestParseETagList(t *testing.T) {
+	assert.Equal(t, []string{`"a"`, `W/"b"`, `"c,d"`}, parseETagList(`"a", W/"b" ,"c,d"`))
+	assert.Equal(t, []string{"*"}, parseETagList("*"))
+	assert.Equal(t, []string{`"a"`}, parseETagList(`"a", bogus`))
+}
+
+func TestCheckPreconditions(t *testing.T) {
+	modtime := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	etag := `"v1"`
+
+	// Test: no preconditions
+	assert.Equal(t, StatusCode(0), CheckPreconditions(conditionalRequest(t, "GET", ""), etag, modtime))
+
+	// Test: If-None-Match uses weak comparison and gives 304 for GET, 412 otherwise
+	assert.Equal(t, notModifiedCode, CheckPreconditions(conditionalRequest(t, "GET", "If-None-Match: \"v0\", W/\"v1\"\r\n"), etag, modtime))
+	assert.Equal(t, preconditionFailedCode, CheckPreconditions(conditionalRequest(t, "PUT", "If-None-Match: *\r\n"), etag, modtime))
+	assert.Equal(t, StatusCode(0), CheckPreconditions(conditionalRequest(t, "GET", "If-None-Match: \"v2\"\r\n"), etag, modtime))
+
+	// Test: If-None-Match takes precedence over If-Modified-Since
+	req := conditionalRequest(t, "GET", "If-None-Match: \"v2\"\r\nIf-Modified-Since: Tue, 02 Jan 2024 03:04:05 GMT\r\n")
+	assert.Equal(t, StatusCode(0), CheckPreconditions(req, etag, modtime))
+
+	// Test: If-Modified-Since
+	assert.Equal(t, notModifiedCode, CheckPreconditions(conditionalRequest(t, "GET", "If-Modified-Since: Tue, 02 Jan 2024 03:04:05 GMT\r\n"), etag, modtime.Add(500*time.Millisecond)))
+	assert.Equal(t, StatusCode(0), CheckPreconditions(conditionalRequest(t, "GET", "If-Modified-Since: Mon, 01 Jan 2024 00:00:00 GMT\r\n"), etag, modtime))
+
+	// Test: If-Match uses strong comparison
+	assert.Equal(t, StatusCode(0), CheckPreconditions(conditionalRequest(t, "PUT", "If-Match: \"v1\"\r\n"), etag, modtime))
+	assert.Equal(t, preconditionFailedCode, CheckPreconditions(conditionalRequest(t, "PUT", "If-Match: W/\"v1\"\r\n"), etag, modtime))
+	assert.Equal(t, preconditionFailedCode, CheckPreconditions(conditionalRequest(t, "PUT", "If-Match: *\r\n"), "", modtime))
+
+	// Test: If-Match takes precedence over If-Unmodified-Since
+	req = conditionalRequest(t, "PUT", "If-Match: \"v1\"\r\nIf-Unmodified-Since: Mon, 01 Jan 2024 00:00:00 GMT\r\n")
+	assert.Equal(t, StatusCode(0), CheckPreconditions(req, etag, modtime))
+	req = conditionalRequest(t, "PUT", "If-Unmodified-Since: Mon, 01 Jan 2024 00:00:00 GMT\r\n")
+	assert.Equal(t, preconditionFailedCode, CheckPreconditions(req, etag, modtime))
+}
+
+func TestServeContentNotModified(t *testing.T) {
+	req := conditionalRequest(t, "GET", "If-None-Match: \"v1\"\r\n")
+	var buf bytes.Buffer
+	w := Writer{Writer: &buf}
+	err := ServeContent(&w, req, "file.txt", time.Time{}, strings.NewReader("0123456789"), headers.Headers{"ETag": `"v1"`, "Cache-Control": "max-age=60"})
+	require.NoError(t, err)
+
+	resp := buf.String()
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 304 Not Modified\r\n"))
+	assert.Contains(t, resp, "ETag: \"v1\"\r\n")
+	assert.Contains(t, resp, "Cache-Control: max-age=60\r\n")
+	assert.NotContains(t, resp, "Content-Length")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\n"))
+}
@@ -0,0 +1,285 @@
+package response
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jsleep/httpfromtcp/internal/headers"
+	"github.com/jsleep/httpfromtcp/internal/request"
+)
+
+// sniffLen is how many bytes are read to guess a Content-Type.
+const sniffLen = 512
+
+// httpDateFormat is the IMF-fixdate format used by Date, Last-Modified and
+// friends.
+const httpDateFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+var errUnsatisfiableRange = errors.New("range not satisfiable")
+
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return "bytes " + strconv.FormatInt(r.start, 10) + "-" + strconv.FormatInt(r.start+r.length-1, 10) + "/" + strconv.FormatInt(size, 10)
+}
+
+// parseRange parses a Range header such as "bytes=0-99, 200-, -50" against
+// content of the given size. Ranges that start past the end are dropped;
+// if none are left errUnsatisfiableRange is returned.
+func parseRange(header string, size int64) ([]byteRange, error) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found {
+		return nil, errors.New("unsupported range unit: " + header)
+	}
+
+	var ranges []byteRange
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		first, last, found := strings.Cut(part, "-")
+		if !found {
+			return nil, errors.New("invalid range: " + part)
+		}
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+
+		var r byteRange
+		if first == "" {
+			// suffix range: the last n bytes
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, errors.New("invalid range: " + part)
+			}
+			// a zero-length suffix, or any suffix of empty content, selects nothing
+			n = min(n, size)
+			if n == 0 {
+				continue
+			}
+			r = byteRange{start: size - n, length: n}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, errors.New("invalid range: " + part)
+			}
+			end := size - 1
+			if last != "" {
+				end, err = strconv.ParseInt(last, 10, 64)
+				if err != nil || end < start {
+					return nil, errors.New("invalid range: " + part)
+				}
+				end = min(end, size-1)
+			}
+			if start >= size {
+				continue
+			}
+			r = byteRange{start: start, length: end - start + 1}
+		}
+		ranges = append(ranges, r)
+	}
+
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiableRange
+	}
+	return ranges, nil
+}
+
+// detectContentType guesses a Content-Type from the file extension, falling
+// back to sniffing the first bytes of content.
+func detectContentType(name string, content io.ReadSeeker) (string, error) {
+	if ctype := mime.TypeByExtension(filepath.Ext(name)); ctype != "" {
+		return ctype, nil
+	}
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(content, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+// ifRangeMatches reports whether a Range request should be honored given
+// the request's If-Range header. Only a strong ETag or an exact
+// Last-Modified date can match.
+func ifRangeMatches(ifRange string, etag string, modtime time.Time) bool {
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) {
+		return etag != "" && !strings.HasPrefix(etag, "W/") && ifRange == etag
+	}
+	t, err := time.Parse(httpDateFormat, ifRange)
+	if err != nil || modtime.IsZero() {
+		return false
+	}
+	return modtime.Truncate(time.Second).Equal(t)
+}
+
+// ServeContent streams content as the response to req, answering Range
+// requests with 206 Partial Content (multipart/byteranges for more than one
+// range) or 416 Range Not Satisfiable, and conditional requests with 304 or
+// 412. name is used to pick a Content-Type when the caller hasn't set one
+// in extra, and modtime feeds Last-Modified. extra holds any additional
+// headers to send; an ETag in it is used for If-Match, If-None-Match and
+// If-Range.
+func ServeContent(w *Writer, req *request.Request, name string, modtime time.Time, content io.ReadSeeker, extra headers.Headers) error {
+	size, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	respHeaders := GetDefaultHeaders(int(size))
+	for key, value := range extra {
+		respHeaders.Set(key, value)
+	}
+	if extra.Get("Content-Type") == "" {
+		ctype, err := detectContentType(name, content)
+		if err != nil {
+			return err
+		}
+		respHeaders["Content-Type"] = ctype
+	}
+	respHeaders["Accept-Ranges"] = "bytes"
+	if !modtime.IsZero() {
+		respHeaders["Last-Modified"] = modtime.UTC().Format(httpDateFormat)
+	}
+
+	if code := CheckPreconditions(req, extra.Get("ETag"), modtime); code != 0 {
+		return w.WritePreconditionResult(code, respHeaders)
+	}
+
+	rangeHeader := req.Headers.Get("range")
+	if rangeHeader == "" || !ifRangeMatches(req.Headers.Get("if-range"), extra.Get("ETag"), modtime) {
+		return writeContent(w, 200, respHeaders, content, size)
+	}
+
+	ranges, err := parseRange(rangeHeader, size)
+	if err == errUnsatisfiableRange {
+		respHeaders = GetDefaultHeaders(0)
+		respHeaders["Content-Range"] = "bytes */" + strconv.FormatInt(size, 10)
+		if err := w.WriteStatusLine(rangeNotSatisfiableCode); err != nil {
+			return err
+		}
+		return w.WriteHeaders(respHeaders)
+	}
+	if err != nil {
+		// a malformed Range header is ignored rather than rejected
+		return writeContent(w, 200, respHeaders, content, size)
+	}
+
+	var total int64
+	for _, r := range ranges {
+		total += r.length
+	}
+	if total > size {
+		// overlapping ranges would cost more than the whole thing
+		return writeContent(w, 200, respHeaders, content, size)
+	}
+
+	if len(ranges) == 1 {
+		r := ranges[0]
+		respHeaders["Content-Range"] = r.contentRange(size)
+		if _, err := content.Seek(r.start, io.SeekStart); err != nil {
+			return err
+		}
+		return writeContent(w, partialContentCode, respHeaders, content, r.length)
+	}
+
+	return writeMultipartRanges(w, respHeaders, content, size, ranges)
+}
+
+func writeContent(w *Writer, statusCode StatusCode, respHeaders headers.Headers, content io.Reader, length int64) error {
+	respHeaders["Content-Length"] = strconv.FormatInt(length, 10)
+	if err := w.WriteStatusLine(statusCode); err != nil {
+		return err
+	}
+	if err := w.WriteHeaders(respHeaders); err != nil {
+		return err
+	}
+	if w.BodyDiscarded() {
+		return nil
+	}
+	_, err := io.CopyN(bodyWriter{w}, content, length)
+	return err
+}
+
+func writeMultipartRanges(w *Writer, respHeaders headers.Headers, content io.ReadSeeker, size int64, ranges []byteRange) error {
+	boundary, err := newBoundary()
+	if err != nil {
+		return err
+	}
+	ctype := respHeaders.Get("Content-Type")
+
+	// work out every part header up front so Content-Length is exact
+	partHeaders := make([]string, len(ranges))
+	length := int64(0)
+	for i, r := range ranges {
+		partHeaders[i] = "\r\n--" + boundary + "\r\n" +
+			"Content-Type: " + ctype + "\r\n" +
+			"Content-Range: " + r.contentRange(size) + "\r\n\r\n"
+		length += int64(len(partHeaders[i])) + r.length
+	}
+	closing := "\r\n--" + boundary + "--\r\n"
+	length += int64(len(closing))
+
+	respHeaders.Set("Content-Type", "multipart/byteranges; boundary="+boundary)
+	respHeaders["Content-Length"] = strconv.FormatInt(length, 10)
+	if err := w.WriteStatusLine(partialContentCode); err != nil {
+		return err
+	}
+	if err := w.WriteHeaders(respHeaders); err != nil {
+		return err
+	}
+
+	if w.BodyDiscarded() {
+		return nil
+	}
+
+	for i, r := range ranges {
+		if _, err := w.WriteBody([]byte(partHeaders[i])); err != nil {
+			return err
+		}
+		if _, err := content.Seek(r.start, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(bodyWriter{w}, content, r.length); err != nil {
+			return err
+		}
+	}
+	_, err = w.WriteBody([]byte(closing))
+	return err
+}
+
+func newBoundary() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// bodyWriter adapts WriteBody to io.Writer for use with io.Copy.
+type bodyWriter struct {
+	w *Writer
+}
+
+func (b bodyWriter) Write(p []byte) (int, error) {
+	return b.w.WriteBody(p)
+}
@@ -0,0 +1,94 @@
+package response
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jsleep/httpfromtcp/internal/headers"
+	"github.com/jsleep/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRange(t *testing.T) {
+	// Test: single, open ended and suffix ranges
+	ranges, err := parseRange("bytes=0-9, 90-, -5", 100)
+	require.NoError(t, err)
+	assert.Equal(t, []byteRange{{0, 10}, {90, 10}, {95, 5}}, ranges)
+
+	// Test: end past the size is clamped
+	ranges, err = parseRange("bytes=50-1000", 100)
+	require.NoError(t, err)
+	assert.Equal(t, []byteRange{{50, 50}}, ranges)
+
+	// Test: start past the size is unsatisfiable
+	_, err = parseRange("bytes=100-", 100)
+	require.ErrorIs(t, err, errUnsatisfiableRange)
+
+	// Test: so is any suffix of empty content
+	_, err = parseRange("bytes=-5", 0)
+	require.ErrorIs(t, err, errUnsatisfiableRange)
+
+	// Test: malformed ranges
+	_, err = parseRange("bytes=9-1", 100)
+	require.Error(t, err)
+	_, err = parseRange("items=0-1", 100)
+	require.Error(t, err)
+}
+
+func serveContent(t *testing.T, rawHeaders string, extra headers.Headers) string {
+	req, err := request.RequestFromReader(strings.NewReader("GET /file HTTP/1.1\r\n" + rawHeaders + "\r\n"))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	w := Writer{Writer: &buf}
+	modtime := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	err = ServeContent(&w, req, "file.txt", modtime, strings.NewReader("0123456789"), extra)
+	require.NoError(t, err)
+	return buf.String()
+}
+
+func TestServeContent(t *testing.T) {
+	// Test: no Range serves everything
+	resp := serveContent(t, "", nil)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, resp, "Accept-Ranges: bytes\r\n")
+	assert.Contains(t, resp, "Last-Modified: Tue, 02 Jan 2024 03:04:05 GMT\r\n")
+	assert.Contains(t, resp, "Content-Type: text/plain; charset=utf-8\r\n")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\n0123456789"))
+
+	// Test: single range
+	resp = serveContent(t, "Range: bytes=2-4\r\n", nil)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 206 Partial Content\r\n"))
+	assert.Contains(t, resp, "Content-Range: bytes 2-4/10\r\n")
+	assert.Contains(t, resp, "Content-Length: 3\r\n")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\n234"))
+
+	// Test: multiple ranges
+	resp = serveContent(t, "Range: bytes=0-1,-2\r\n", nil)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 206 Partial Content\r\n"))
+	assert.Contains(t, resp, "Content-Type: multipart/byteranges; boundary=")
+	assert.Contains(t, resp, "Content-Range: bytes 0-1/10\r\n\r\n01\r\n")
+	assert.Contains(t, resp, "Content-Range: bytes 8-9/10\r\n\r\n89\r\n")
+	_, body, _ := strings.Cut(resp, "\r\n\r\n")
+	assert.Contains(t, resp, "Content-Length: "+strconv.Itoa(len(body))+"\r\n")
+
+	// Test: unsatisfiable range
+	resp = serveContent(t, "Range: bytes=20-\r\n", nil)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 416 Range Not Satisfiable\r\n"))
+	assert.Contains(t, resp, "Content-Range: bytes */10\r\n")
+
+	// Test: If-Range with a matching date or strong ETag honors the range
+	resp = serveContent(t, "Range: bytes=0-0\r\nIf-Range: Tue, 02 Jan 2024 03:04:05 GMT\r\n", nil)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 206"))
+	resp = serveContent(t, "Range: bytes=0-0\r\nIf-Range: \"v1\"\r\n", headers.Headers{"ETag": `"v1"`})
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 206"))
+
+	// Test: If-Range that doesn't match serves everything
+	resp = serveContent(t, "Range: bytes=0-0\r\nIf-Range: \"v2\"\r\n", headers.Headers{"ETag": `"v1"`})
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200"))
+	assert.True(t, strings.HasSuffix(resp, "0123456789"))
+}
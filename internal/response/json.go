@@ -0,0 +1,78 @@
+package response
+
+import (
+	"bufio"
+	"encoding/json"
+
+	"github.com/jsleep/httpfromtcp/internal/headers"
+)
+
+const jsonContentType = "application/json; charset=utf-8"
+
+// WriteJSON marshals v and writes it as a complete response with the given
+// status code. Nothing is written if v can't be marshaled.
+func (w *Writer) WriteJSON(statusCode StatusCode, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	body = append(body, '\n')
+
+	headers := GetDefaultHeaders(len(body))
+	headers["Content-Type"] = jsonContentType
+
+	if err := w.WriteStatusLine(statusCode); err != nil {
+		return err
+	}
+	if err := w.WriteHeaders(headers); err != nil {
+		return err
+	}
+	_, err = w.WriteBody(body)
+	return err
+}
+
+// WriteJSONStream encodes v straight onto the connection using chunked
+// transfer encoding, so large values don't need to be marshaled into
+// memory up front.
+func (w *Writer) WriteJSONStream(statusCode StatusCode, v any) error {
+	streamHeaders := GetDefaultHeaders(0)
+	delete(streamHeaders, "Content-Length")
+	streamHeaders["Content-Type"] = jsonContentType
+	streamHeaders["Transfer-Encoding"] = "chunked"
+
+	if err := w.WriteStatusLine(statusCode); err != nil {
+		return err
+	}
+	if err := w.WriteHeaders(streamHeaders); err != nil {
+		return err
+	}
+
+	buffered := bufio.NewWriter(ChunkedWriter{w})
+	if err := json.NewEncoder(buffered).Encode(v); err != nil {
+		return err
+	}
+	if err := buffered.Flush(); err != nil {
+		return err
+	}
+	if _, err := w.WriteChunkedBodyDone(); err != nil {
+		return err
+	}
+	return w.WriteTrailers(headers.NewHeaders())
+}
+
+// ChunkedWriter adapts a Writer to io.Writer, sending each Write as one
+// chunk of a chunked body.
+type ChunkedWriter struct {
+	W *Writer
+}
+
+func (cw ChunkedWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		// a zero length chunk would end the body
+		return 0, nil
+	}
+	if _, err := cw.W.WriteChunkedBody(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
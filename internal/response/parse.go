@@ -0,0 +1,286 @@
+package response
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/jsleep/httpfromtcp/internal/headers"
+)
+
+// Response is a response read from a server.
+type Response struct {
+	StatusLine StatusLine
+	Headers    headers.Headers
+
+	// SetCookies are the raw Set-Cookie values, which can't be folded into
+	// one header like other repeated fields.
+	SetCookies []string
+
+	// Body streams the body as it arrives, already unchunked. Trailers
+	// are filled in once it has been read to the end.
+	Body     io.ReadCloser
+	Trailers headers.Headers
+
+	// ContentLength is the length of the body, or -1 when it isn't known
+	// up front.
+	ContentLength int64
+
+	// Close is set when the connection can't carry another response after
+	// this one.
+	Close bool
+}
+
+type StatusLine struct {
+	HttpVersion  string
+	StatusCode   StatusCode
+	ReasonPhrase string
+}
+
+// ResponseFromReader reads a response to a GET request.
+func ResponseFromReader(reader io.Reader) (*Response, error) {
+	return ReadResponse(bufio.NewReader(reader), "GET")
+}
+
+// ReadResponse reads the status line and headers of a response to a
+// request with method from reader, leaving the body to be read from the
+// response's Body.
+func ReadResponse(reader *bufio.Reader, method string) (*Response, error) {
+	line, err := readLine(reader)
+	if err != nil {
+		return nil, err
+	}
+	statusLine, err := parseStatusLine(line)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &Response{
+		StatusLine: *statusLine,
+		Headers:    headers.NewHeaders(),
+		Trailers:   headers.NewHeaders(),
+	}
+	if err := readHeaders(reader, resp.Headers, &resp.SetCookies); err != nil {
+		return nil, err
+	}
+
+	connection := strings.ToLower(resp.Headers.Get("connection"))
+	if statusLine.HttpVersion == "1.0" {
+		resp.Close = !strings.Contains(connection, "keep-alive")
+	} else {
+		resp.Close = strings.Contains(connection, "close")
+	}
+
+	if err := resp.frameBody(reader, method); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// readLine reads one CRLF terminated line, without the CRLF.
+func readLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		if err == io.EOF && line != "" {
+			return "", io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	if !strings.HasSuffix(line, "\r\n") {
+		return "", errors.New("expected line to end with CRLF, got: " + line)
+	}
+	return strings.TrimSuffix(line, "\r\n"), nil
+}
+
+func parseStatusLine(line string) (*StatusLine, error) {
+	// the reason phrase can be empty or contain spaces
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) < 2 {
+		return nil, errors.New("expected at least 2 parts in status line, got: " + line)
+	}
+
+	version, ok := strings.CutPrefix(parts[0], "HTTP/")
+	if !ok || (version != "1.1" && version != "1.0") {
+		return nil, errors.New("expected HTTP version to be HTTP/1.1 or HTTP/1.0, got: " + parts[0])
+	}
+	code, err := strconv.Atoi(parts[1])
+	if err != nil || len(parts[1]) != 3 || code < 100 {
+		return nil, errors.New("expected status code to be 3 digits, got: " + parts[1])
+	}
+
+	statusLine := &StatusLine{HttpVersion: version, StatusCode: StatusCode(code)}
+	if len(parts) == 3 {
+		statusLine.ReasonPhrase = parts[2]
+	}
+	return statusLine, nil
+}
+
+// readHeaders parses header lines into h up to the blank line that ends
+// them, keeping every Set-Cookie value in cookies when it's non-nil.
+func readHeaders(reader *bufio.Reader, h headers.Headers, cookies *[]string) error {
+	for {
+		line, err := readLine(reader)
+		if err != nil {
+			return err
+		}
+		if line == "" {
+			return nil
+		}
+		if _, _, err := h.Parse([]byte(line + "\r\n")); err != nil {
+			return err
+		}
+		if name, value, _ := strings.Cut(line, ":"); cookies != nil && strings.EqualFold(name, "set-cookie") {
+			*cookies = append(*cookies, strings.TrimSpace(value))
+		}
+	}
+}
+
+// frameBody works out where the body ends (RFC 9112 section 6.3).
+func (r *Response) frameBody(reader *bufio.Reader, method string) error {
+	status := r.StatusLine.StatusCode
+	if method == "HEAD" || status < 200 || status == 204 || status == 304 {
+		r.ContentLength = 0
+		r.Body = &body{reader: strings.NewReader("")}
+		return nil
+	}
+
+	if transferEncoding := r.Headers.Get("transfer-encoding"); transferEncoding != "" {
+		codings := strings.Split(transferEncoding, ",")
+		if strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked") {
+			r.ContentLength = -1
+			r.Body = &body{reader: &chunkedReader{reader: reader, trailers: r.Trailers}}
+			return nil
+		}
+		// anything else runs until the server closes the connection
+		r.ContentLength = -1
+		r.Close = true
+		r.Body = &body{reader: reader}
+		return nil
+	}
+
+	if contentLength := r.Headers.Get("content-length"); contentLength != "" {
+		length, err := strconv.ParseInt(contentLength, 10, 64)
+		if err != nil || length < 0 {
+			return errors.New("invalid content-length header: " + contentLength)
+		}
+		r.ContentLength = length
+		r.Body = &body{reader: &lengthReader{reader: reader, remaining: length}}
+		return nil
+	}
+
+	r.ContentLength = -1
+	r.Close = true
+	r.Body = &body{reader: reader}
+	return nil
+}
+
+// body stops reads once it's closed, so a caller can't read into the
+// next response on the connection.
+type body struct {
+	reader io.Reader
+	closed bool
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, errors.New("read on closed response body")
+	}
+	return b.reader.Read(p)
+}
+
+func (b *body) Close() error {
+	b.closed = true
+	return nil
+}
+
+// lengthReader reads a body of known length, which must all arrive.
+type lengthReader struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func (l *lengthReader) Read(p []byte) (int, error) {
+	if l.remaining == 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.reader.Read(p)
+	l.remaining -= int64(n)
+	if err == io.EOF && l.remaining > 0 {
+		return n, io.ErrUnexpectedEOF
+	}
+	if err == nil && l.remaining == 0 {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// chunkedReader reads a chunked body and then its trailers.
+type chunkedReader struct {
+	reader    *bufio.Reader
+	trailers  headers.Headers
+	remaining int64 // in the current chunk
+	done      bool
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if c.done {
+		return 0, io.EOF
+	}
+	if c.remaining == 0 {
+		size, err := c.nextChunk()
+		if err != nil {
+			return 0, err
+		}
+		if size == 0 {
+			if err := readHeaders(c.reader, c.trailers, nil); err != nil {
+				return 0, eofIsUnexpected(err)
+			}
+			c.done = true
+			return 0, io.EOF
+		}
+		c.remaining = size
+	}
+
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	// hand over whatever has arrived rather than waiting for the whole
+	// chunk, so streamed responses stream
+	n, err := c.reader.Read(p)
+	c.remaining -= int64(n)
+	if err != nil {
+		return n, eofIsUnexpected(err)
+	}
+	if c.remaining == 0 {
+		if line, err := readLine(c.reader); err != nil || line != "" {
+			return n, errors.New("expected CRLF after chunk data")
+		}
+	}
+	return n, nil
+}
+
+// nextChunk reads a chunk size line. Chunk extensions are ignored.
+func (c *chunkedReader) nextChunk() (int64, error) {
+	line, err := readLine(c.reader)
+	if err != nil {
+		return 0, eofIsUnexpected(err)
+	}
+	sizeText, _, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(strings.TrimSpace(sizeText), 16, 64)
+	if err != nil || size < 0 {
+		return 0, errors.New("invalid chunk size: " + line)
+	}
+	return size, nil
+}
+
+func eofIsUnexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,93 @@
+package response
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseFromReader(t *testing.T) {
+	// Test: status line, headers and a Content-Length body
+	resp, err := ResponseFromReader(strings.NewReader("HTTP/1.1 201 Created\r\nContent-Type: text/plain\r\nContent-Length: 5\r\nSet-Cookie: a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT\r\nSet-Cookie: b=2\r\n\r\nhello"))
+	require.NoError(t, err)
+	assert.Equal(t, "1.1", resp.StatusLine.HttpVersion)
+	assert.Equal(t, StatusCode(201), resp.StatusLine.StatusCode)
+	assert.Equal(t, "Created", resp.StatusLine.ReasonPhrase)
+	assert.Equal(t, "text/plain", resp.Headers.Get("Content-Type"))
+	assert.Equal(t, []string{"a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT", "b=2"}, resp.SetCookies)
+	assert.Equal(t, int64(5), resp.ContentLength)
+	assert.False(t, resp.Close)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+
+	// Test: an empty reason phrase
+	resp, err = ResponseFromReader(strings.NewReader("HTTP/1.1 204 \r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, StatusCode(204), resp.StatusLine.StatusCode)
+	assert.Empty(t, resp.StatusLine.ReasonPhrase)
+
+	// Test: malformed status lines
+	for _, line := range []string{"HTTP/2 200 OK", "HTTP/1.1 20 OK", "HTTP/1.1", "HTTP/1.1 OK 200"} {
+		_, err = ResponseFromReader(strings.NewReader(line + "\r\n\r\n"))
+		require.Error(t, err, line)
+	}
+}
+
+func TestChunkedResponse(t *testing.T) {
+	// Test: chunks joined back together, extensions ignored, then trailers
+	resp, err := ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Checksum\r\n\r\n" +
+		"6\r\nhello \r\n5;ext=1\r\nworld\r\n0\r\nX-Checksum: abc123\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), resp.ContentLength)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+	assert.Equal(t, "abc123", resp.Trailers.Get("X-Checksum"))
+
+	// Test: cut short
+	resp, err = ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n6\r\nhel"))
+	require.NoError(t, err)
+	_, err = io.ReadAll(resp.Body)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestBodyFraming(t *testing.T) {
+	// Test: without a length the body runs until the connection closes
+	resp, err := ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\n\r\nuntil the end"))
+	require.NoError(t, err)
+	assert.True(t, resp.Close)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "until the end", string(body))
+
+	// Test: responses that never have a body, whatever they say
+	reader := bufio.NewReader(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nHTTP/1.1 304 Not Modified\r\nContent-Length: 5\r\n\r\n"))
+	resp, err = ReadResponse(reader, "HEAD")
+	require.NoError(t, err)
+	body, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Empty(t, body)
+	resp, err = ReadResponse(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, StatusCode(304), resp.StatusLine.StatusCode)
+	assert.Equal(t, int64(0), resp.ContentLength)
+
+	// Test: a body shorter than its Content-Length
+	resp, err = ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nshort"))
+	require.NoError(t, err)
+	_, err = io.ReadAll(resp.Body)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// Test: HTTP/1.0 closes unless asked not to
+	resp, err = ResponseFromReader(strings.NewReader("HTTP/1.0 200 OK\r\nContent-Length: 0\r\n\r\n"))
+	require.NoError(t, err)
+	assert.True(t, resp.Close)
+	resp, err = ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nConnection: close\r\nContent-Length: 0\r\n\r\n"))
+	require.NoError(t, err)
+	assert.True(t, resp.Close)
+}
@@ -1,34 +1,165 @@
 package response
 
 import (
+	"errors"
 	"io"
+	"net"
 	"strconv"
+	"sync/atomic"
+	"time"
 
+	"github.com/jsleep/httpfromtcp/internal/cookie"
 	"github.com/jsleep/httpfromtcp/internal/headers"
 )
 
 type StatusCode int
 
 const (
-	successCode             StatusCode = 200
-	badRequestCode          StatusCode = 400
-	internalServerErrorCode StatusCode = 500
+	continueCode                StatusCode = 100
+	switchingProtocolsCode      StatusCode = 101
+	successCode                 StatusCode = 200
+	createdCode                 StatusCode = 201
+	acceptedCode                StatusCode = 202
+	nonAuthoritativeInfoCode    StatusCode = 203
+	noContentCode               StatusCode = 204
+	resetContentCode            StatusCode = 205
+	partialContentCode          StatusCode = 206
+	multipleChoicesCode         StatusCode = 300
+	movedPermanentlyCode        StatusCode = 301
+	foundCode                   StatusCode = 302
+	seeOtherCode                StatusCode = 303
+	notModifiedCode             StatusCode = 304
+	temporaryRedirectCode       StatusCode = 307
+	permanentRedirectCode       StatusCode = 308
+	badRequestCode              StatusCode = 400
+	unauthorizedCode            StatusCode = 401
+	forbiddenCode               StatusCode = 403
+	notFoundCode                StatusCode = 404
+	methodNotAllowedCode        StatusCode = 405
+	notAcceptableCode           StatusCode = 406
+	proxyAuthRequiredCode       StatusCode = 407
+	requestTimeoutCode          StatusCode = 408
+	conflictCode                StatusCode = 409
+	goneCode                    StatusCode = 410
+	lengthRequiredCode          StatusCode = 411
+	preconditionFailedCode      StatusCode = 412
+	payloadTooLargeCode         StatusCode = 413
+	uriTooLongCode              StatusCode = 414
+	unsupportedMediaTypeCode    StatusCode = 415
+	rangeNotSatisfiableCode     StatusCode = 416
+	unprocessableContentCode    StatusCode = 422
+	upgradeRequiredCode         StatusCode = 426
+	preconditionRequiredCode    StatusCode = 428
+	tooManyRequestsCode         StatusCode = 429
+	headerFieldsTooLargeCode    StatusCode = 431
+	internalServerErrorCode     StatusCode = 500
+	notImplementedCode          StatusCode = 501
+	badGatewayCode              StatusCode = 502
+	serviceUnavailableCode      StatusCode = 503
+	gatewayTimeoutCode          StatusCode = 504
+	httpVersionNotSupportedCode StatusCode = 505
 )
 
 func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
+	w.status = statusCode
 	statusLine := "HTTP/1.1 " + strconv.Itoa(int(statusCode)) + " " + statusText(statusCode) + "\r\n"
 	_, err := w.Write([]byte(statusLine))
 	return err
 }
 
+// StatusText returns the reason phrase for code.
+func StatusText(code StatusCode) string {
+	return statusText(code)
+}
+
 func statusText(code StatusCode) string {
 	switch code {
+	case continueCode:
+		return "Continue"
+	case switchingProtocolsCode:
+		return "Switching Protocols"
 	case successCode:
 		return "OK"
+	case createdCode:
+		return "Created"
+	case acceptedCode:
+		return "Accepted"
+	case nonAuthoritativeInfoCode:
+		return "Non-Authoritative Information"
+	case noContentCode:
+		return "No Content"
+	case resetContentCode:
+		return "Reset Content"
+	case partialContentCode:
+		return "Partial Content"
+	case multipleChoicesCode:
+		return "Multiple Choices"
+	case movedPermanentlyCode:
+		return "Moved Permanently"
+	case foundCode:
+		return "Found"
+	case seeOtherCode:
+		return "See Other"
+	case notModifiedCode:
+		return "Not Modified"
+	case temporaryRedirectCode:
+		return "Temporary Redirect"
+	case permanentRedirectCode:
+		return "Permanent Redirect"
 	case badRequestCode:
 		return "Bad Request"
+	case unauthorizedCode:
+		return "Unauthorized"
+	case forbiddenCode:
+		return "Forbidden"
+	case notFoundCode:
+		return "Not Found"
+	case methodNotAllowedCode:
+		return "Method Not Allowed"
+	case notAcceptableCode:
+		return "Not Acceptable"
+	case proxyAuthRequiredCode:
+		return "Proxy Authentication Required"
+	case requestTimeoutCode:
+		return "Request Timeout"
+	case conflictCode:
+		return "Conflict"
+	case goneCode:
+		return "Gone"
+	case lengthRequiredCode:
+		return "Length Required"
+	case preconditionFailedCode:
+		return "Precondition Failed"
+	case payloadTooLargeCode:
+		return "Content Too Large"
+	case uriTooLongCode:
+		return "URI Too Long"
+	case unsupportedMediaTypeCode:
+		return "Unsupported Media Type"
+	case rangeNotSatisfiableCode:
+		return "Range Not Satisfiable"
+	case unprocessableContentCode:
+		return "Unprocessable Content"
+	case upgradeRequiredCode:
+		return "Upgrade Required"
+	case preconditionRequiredCode:
+		return "Precondition Required"
+	case tooManyRequestsCode:
+		return "Too Many Requests"
+	case headerFieldsTooLargeCode:
+		return "Request Header Fields Too Large"
 	case internalServerErrorCode:
 		return "Internal Server Error"
+	case notImplementedCode:
+		return "Not Implemented"
+	case badGatewayCode:
+		return "Bad Gateway"
+	case serviceUnavailableCode:
+		return "Service Unavailable"
+	case gatewayTimeoutCode:
+		return "Gateway Timeout"
+	case httpVersionNotSupportedCode:
+		return "HTTP Version Not Supported"
 	default:
 		return "Unknown Status"
 	}
@@ -42,22 +173,63 @@ func GetDefaultHeaders(contentLen int) headers.Headers {
 	}
 }
 
-func (w *Writer) WriteHeaders(headers headers.Headers) error {
-	for key, value := range headers {
-		headerLine := key + ": " + value + "\r\n"
+type cachedDate struct {
+	unix      int64
+	formatted string
+}
+
+var currentDate atomic.Pointer[cachedDate]
+
+// Date returns the current time formatted for a Date header. Formatting is
+// only redone when the second changes, since every response needs one.
+func Date() string {
+	now := time.Now()
+	if cached := currentDate.Load(); cached != nil && cached.unix == now.Unix() {
+		return cached.formatted
+	}
+	cached := &cachedDate{unix: now.Unix(), formatted: now.UTC().Format(httpDateFormat)}
+	currentDate.Store(cached)
+	return cached.formatted
+}
+
+func (w *Writer) WriteHeaders(h headers.Headers) error {
+	hooks := w.beforeHeaders
+	w.beforeHeaders = nil
+	for _, fn := range hooks {
+		fn(w, h)
+	}
+
+	for key, value := range h {
+		headerLine := headers.Canonical(key) + ": " + value + "\r\n"
 		if _, err := w.Write([]byte(headerLine)); err != nil {
 			return err
 		}
 	}
+	// Set-Cookie can't be folded into one line, so each cookie gets its own
+	for _, c := range w.cookies {
+		if _, err := w.Write([]byte("Set-Cookie: " + c.String() + "\r\n")); err != nil {
+			return err
+		}
+	}
+	for _, raw := range w.rawCookies {
+		if _, err := w.Write([]byte("Set-Cookie: " + raw + "\r\n")); err != nil {
+			return err
+		}
+	}
+	w.cookies = nil
+	w.rawCookies = nil
 	// Write the final CRLF to indicate the end of headers
 	w.Write([]byte("\r\n"))
 
 	return nil
 }
 
-func (w *Writer) WriteTrailers(headers headers.Headers) error {
-	for key, value := range headers {
-		headerLine := key + ": " + value + "\r\n"
+func (w *Writer) WriteTrailers(h headers.Headers) error {
+	if w.discardBody {
+		return nil
+	}
+	for key, value := range h {
+		headerLine := headers.Canonical(key) + ": " + value + "\r\n"
 		if _, err := w.Write([]byte(headerLine)); err != nil {
 			return err
 		}
@@ -69,6 +241,12 @@ func (w *Writer) WriteTrailers(headers headers.Headers) error {
 }
 
 func (w *Writer) WriteBody(Body []byte) (int, error) {
+	if w.discardBody {
+		return len(Body), nil
+	}
+	if w.body != nil {
+		return w.body.Write(Body)
+	}
 	n, err := w.Write(Body)
 	if err != nil {
 		return 0, err
@@ -77,6 +255,13 @@ func (w *Writer) WriteBody(Body []byte) (int, error) {
 }
 
 func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
+	if w.discardBody {
+		return len(p), nil
+	}
+	if w.body != nil {
+		// the filter does its own chunk framing
+		return w.body.Write(p)
+	}
 	// hexadecimal representation of the length of the chunk
 	chunkHeader := strconv.FormatInt(int64(len(p)), 16) + "\r\n"
 	if _, err := w.Write([]byte(chunkHeader)); err != nil {
@@ -93,6 +278,14 @@ func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 }
 
 func (w *Writer) WriteChunkedBodyDone() (int, error) {
+	if w.discardBody {
+		return 0, nil
+	}
+	if w.body != nil {
+		if err := w.body.Close(); err != nil {
+			return 0, err
+		}
+	}
 	// Write the final chunk with length 0 to indicate the end of the chunked body
 	if _, err := w.Write([]byte("0\r\n")); err != nil {
 		return 0, err
@@ -102,4 +295,101 @@ func (w *Writer) WriteChunkedBodyDone() (int, error) {
 
 type Writer struct {
 	io.Writer
+
+	cookies       []*cookie.Cookie
+	rawCookies    []string
+	beforeHeaders []func(w *Writer, h headers.Headers)
+	body          BodyFilter
+	status        StatusCode
+	discardBody   bool
+	hijack        func() (net.Conn, []byte, error)
+}
+
+// DiscardBody makes every body, chunk and trailer write a no-op that still
+// reports success, while the status line and headers go out as usual. The
+// server uses it to answer HEAD requests with GET handlers.
+func (w *Writer) DiscardBody() {
+	w.discardBody = true
+}
+
+// BodyDiscarded reports whether DiscardBody was called, so handlers can
+// skip producing a body nobody will see.
+func (w *Writer) BodyDiscarded() bool {
+	return w.discardBody
+}
+
+// Status returns the status code written by WriteStatusLine, or 0 if the
+// status line hasn't been written yet.
+func (w *Writer) Status() StatusCode {
+	return w.status
+}
+
+// BodyFilter transforms body bytes on their way to the connection, e.g. to
+// compress them. The filter writes straight to the underlying connection
+// and does its own chunk framing.
+type BodyFilter interface {
+	io.Writer
+	// Close flushes anything still buffered. WriteChunkedBodyDone calls it
+	// before writing the last chunk.
+	Close() error
+}
+
+// SetBodyFilter routes everything written with WriteBody and
+// WriteChunkedBody through f. It's meant to be called from an
+// OnWriteHeaders hook, once the filter has adjusted the headers to match.
+func (w *Writer) SetBodyFilter(f BodyFilter) {
+	w.body = f
+}
+
+// AddSetCookie queues a Set-Cookie line with value sent exactly as given,
+// for passing on cookies set elsewhere, e.g. by an upstream server.
+func (w *Writer) AddSetCookie(value string) {
+	w.rawCookies = append(w.rawCookies, value)
+}
+
+// Flush pushes out anything the body filter is holding back, so a streamed
+// chunk reaches the client right away. Without a filter, writes go straight
+// to the connection and there's nothing to flush.
+func (w *Writer) Flush() error {
+	if f, ok := w.body.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// OnWriteHeaders registers fn to run at the start of WriteHeaders, before
+// anything is written. fn may add cookies or change the headers about to be
+// sent, which lets middleware react to what the handler did.
+func (w *Writer) OnWriteHeaders(fn func(w *Writer, h headers.Headers)) {
+	w.beforeHeaders = append(w.beforeHeaders, fn)
+}
+
+// SetCookie queues a cookie to be sent as its own Set-Cookie line by the
+// next call to WriteHeaders.
+func (w *Writer) SetCookie(c *cookie.Cookie) error {
+	if err := c.Valid(); err != nil {
+		return err
+	}
+	w.cookies = append(w.cookies, c)
+	return nil
+}
+
+// SetHijacker makes fn the way Hijack gets hold of the connection. The
+// server sets it for every request it reads.
+func (w *Writer) SetHijacker(fn func() (net.Conn, []byte, error)) {
+	w.hijack = fn
+}
+
+// Hijack hands the connection over to the caller, e.g. to speak another
+// protocol after a 101 Switching Protocols or to tunnel a CONNECT. Along
+// with it come the bytes the server already read past the end of the
+// request, which the caller must treat as the start of what it reads from
+// the connection. The server no longer reads from, writes to or closes the
+// connection once the handler returns. Call it from the handler's
+// goroutine, before the handler returns.
+func (w *Writer) Hijack() (net.Conn, []byte, error) {
+	if w.hijack == nil {
+		return nil, nil, errors.New("connection can't be hijacked")
+	}
+	return w.hijack()
 }
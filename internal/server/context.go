@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/jsleep/httpfromtcp/internal/request"
+	"github.com/jsleep/httpfromtcp/internal/response"
+)
+
+// Timeout gives requests passed to next a deadline: their context is
+// canceled once d has passed. Handlers that watch req.Context() can give up
+// and return an error. Wrap a single route to give it its own deadline.
+func Timeout(d time.Duration, next Handler) Handler {
+	return func(w response.Writer, req *request.Request) *HandlerError {
+		ctx, cancel := context.WithTimeout(req.Context(), d)
+		defer cancel()
+		return next(w, req.WithContext(ctx))
+	}
+}
+
+// aLongTimeAgo is a deadline in the past, which makes a blocked Read
+// return right away.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// disconnectWatcher reads from a connection while its handler runs, since
+// reading is the only way to notice the client hanging up. The server
+// closes the connection after every response, so a client has no reason
+// to send anything more unless the connection is being hijacked; if it
+// does, the watcher keeps the first byte and stops watching.
+type disconnectWatcher struct {
+	conn net.Conn
+	done chan struct{}
+	buf  [1]byte
+	n    int
+}
+
+func watchDisconnect(conn net.Conn, cancel context.CancelFunc) *disconnectWatcher {
+	d := &disconnectWatcher{conn: conn, done: make(chan struct{})}
+	go func() {
+		defer close(d.done)
+		n, err := conn.Read(d.buf[:])
+		d.n = n
+		var netErr net.Error
+		if n == 0 && err != nil && !(errors.As(err, &netErr) && netErr.Timeout()) {
+			cancel()
+		}
+	}()
+	return d
+}
+
+// stop ends the read and returns what it picked up, so a hijacked
+// connection doesn't lose it.
+func (d *disconnectWatcher) stop() []byte {
+	d.conn.SetReadDeadline(aLongTimeAgo)
+	<-d.done
+	d.conn.SetReadDeadline(time.Time{})
+	return d.buf[:d.n]
+}
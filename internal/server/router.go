@@ -0,0 +1,126 @@
+package server
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/jsleep/httpfromtcp/internal/request"
+	"github.com/jsleep/httpfromtcp/internal/response"
+)
+
+// Router dispatches requests by method and path. A pattern ending in "/"
+// matches every path under it, anything else matches exactly; the longest
+// matching pattern wins.
+//
+// Because it knows which methods each path supports, the router answers
+// OPTIONS (including "OPTIONS *") and 405 Method Not Allowed with an Allow
+// header by itself, and runs GET handlers for HEAD.
+type Router struct {
+	routes map[string]map[string]Handler // pattern -> method -> handler
+}
+
+func NewRouter() *Router {
+	return &Router{routes: map[string]map[string]Handler{}}
+}
+
+func (r *Router) Handle(method string, pattern string, handler Handler) {
+	if r.routes[pattern] == nil {
+		r.routes[pattern] = map[string]Handler{}
+	}
+	r.routes[pattern][strings.ToUpper(method)] = handler
+}
+
+func (r *Router) match(path string) (string, bool) {
+	best := ""
+	found := false
+	for pattern := range r.routes {
+		matches := pattern == path || (strings.HasSuffix(pattern, "/") && strings.HasPrefix(path, pattern))
+		if matches && len(pattern) >= len(best) {
+			best, found = pattern, true
+		}
+	}
+	return best, found
+}
+
+func allowedMethods(handlers map[string]Handler, allowed map[string]bool) {
+	for method := range handlers {
+		allowed[method] = true
+		if method == "GET" {
+			allowed["HEAD"] = true
+		}
+	}
+	allowed["OPTIONS"] = true
+}
+
+func sortedMethods(allowed map[string]bool) []string {
+	methods := make([]string, 0, len(allowed))
+	for method := range allowed {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// Methods returns the methods that can be used on path, or nil if no
+// route matches it. "*" returns the methods of every route.
+func (r *Router) Methods(path string) []string {
+	allowed := map[string]bool{}
+	if path == "*" {
+		for _, handlers := range r.routes {
+			allowedMethods(handlers, allowed)
+		}
+		return sortedMethods(allowed)
+	}
+
+	pattern, found := r.match(path)
+	if !found {
+		return nil
+	}
+	allowedMethods(r.routes[pattern], allowed)
+	return sortedMethods(allowed)
+}
+
+func (r *Router) Serve(w response.Writer, req *request.Request) *HandlerError {
+	method := req.RequestLine.Method
+
+	if req.RequestLine.RequestTarget == "*" {
+		if method != "OPTIONS" {
+			return &HandlerError{Code: 400, Message: "Bad Request"}
+		}
+		writeAllow(&w, 204, r.Methods("*"))
+		return nil
+	}
+
+	pattern, found := r.match(req.Path())
+	if !found {
+		return &HandlerError{Code: 404, Message: "Not Found"}
+	}
+	handlers := r.routes[pattern]
+
+	handler, ok := handlers[method]
+	if !ok && method == "HEAD" {
+		handler, ok = handlers["GET"]
+	}
+	if ok {
+		return handler(w, req)
+	}
+
+	if method == "OPTIONS" {
+		writeAllow(&w, 204, r.Methods(req.Path()))
+		return nil
+	}
+	writeAllow(&w, 405, r.Methods(req.Path()))
+	return nil
+}
+
+func writeAllow(w *response.Writer, statusCode response.StatusCode, methods []string) {
+	allowHeaders := response.GetDefaultHeaders(0)
+	allowHeaders["Allow"] = strings.Join(methods, ", ")
+	if statusCode == 204 {
+		// a 204 has no body to describe
+		delete(allowHeaders, "Content-Length")
+		delete(allowHeaders, "Content-Type")
+	}
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(allowHeaders)
+}
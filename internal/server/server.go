@@ -1,10 +1,17 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
 	"net"
+	"strconv"
 	"sync/atomic"
 
+	"github.com/jsleep/httpfromtcp/internal/headers"
 	"github.com/jsleep/httpfromtcp/internal/request"
 	"github.com/jsleep/httpfromtcp/internal/response"
 )
@@ -13,25 +20,52 @@ type Server struct {
 	Port     int
 	Listener net.Listener
 	Closed   atomic.Bool
+
+	// Name is sent as the Server header when set.
+	Name string
+	// OmitDate stops the server adding a Date header to responses.
+	OmitDate bool
+
+	// ctx is the parent of every request's context, canceled by Close.
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 func Serve(port int, handler Handler) (*Server, error) {
+	server := &Server{Port: port}
+	if err := server.Start(handler); err != nil {
+		return nil, err
+	}
+	return server, nil
+}
 
-	ln, err := net.Listen("tcp", ":42069")
+// Start listens on s.Port and serves connections in the background. Set any
+// configuration fields before calling it.
+func (s *Server) Start(handler Handler) error {
+	ln, err := net.Listen("tcp", ":"+strconv.Itoa(s.Port))
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	server := &Server{Port: port, Listener: ln}
-	server.Closed.Store(false)
+	s.serve(ln, handler)
+	return nil
+}
 
-	go server.listen(handler)
+func (s *Server) serve(ln net.Listener, handler Handler) {
+	s.Listener = ln
+	s.Closed.Store(false)
+	s.ctx, s.cancel = context.WithCancel(context.Background())
 
-	return server, nil
+	go s.listen(handler)
 }
 
+// Close stops accepting connections and cancels the context of every
+// request still being handled.
 func (s *Server) Close() error {
 	s.Closed.Store(true)
+	if s.cancel != nil {
+		s.cancel()
+	}
 	if s.Listener != nil {
 		return s.Listener.Close()
 	}
@@ -57,25 +91,80 @@ func (s *Server) listen(handler Handler) {
 const respBody = "Hello World!\r\n"
 
 func (s *Server) handle(conn net.Conn, handler Handler) {
-	req, err := request.RequestFromReader(conn)
+	w := response.Writer{Writer: conn}
+	w.OnWriteHeaders(s.addDefaultHeaders)
+
+	var buffered []byte
+	var watcher *disconnectWatcher
+	hijacked := false
+	w.SetHijacker(func() (net.Conn, []byte, error) {
+		if hijacked {
+			return nil, nil, errors.New("connection already hijacked")
+		}
+		hijacked = true
+		if watcher != nil {
+			buffered = append(buffered, watcher.stop()...)
+		}
+		return conn, buffered, nil
+	})
+
+	req, buffered, err := request.ReadRequest(conn)
 	if err != nil {
 		fmt.Printf("Error parsing request: %v\n", err)
+		(&HandlerError{Code: 400, Message: "Bad Request"}).Write(w, nil)
+		conn.Close()
+		return
 	}
 
 	req.Print()
+	req.RemoteAddr = conn.RemoteAddr().String()
 
-	w := response.Writer{Writer: conn}
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		// the handshake has completed by the time a request was read
+		state := tlsConn.ConnectionState()
+		req.TLS = &state
+	}
+
+	// a HEAD response carries the same headers as GET, so let the handler
+	// run unchanged and drop whatever body it writes
+	if req.RequestLine.Method == "HEAD" {
+		w.DiscardBody()
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+	req = req.WithContext(ctx)
+	watcher = watchDisconnect(conn, cancel)
 
 	handlerError := handler(w, req)
 
+	if hijacked {
+		// the handler owns the connection now, it's too late to send an error
+		if handlerError != nil {
+			fmt.Printf("Error handling hijacked request: %v\n", handlerError)
+		}
+		return
+	}
+
 	if handlerError != nil {
 		fmt.Printf("Error handling request: %v\n", handlerError)
-		return
+		handlerError.Write(w, req)
 	}
 
 	conn.Close()
 }
 
+// addDefaultHeaders fills in the headers every response should carry unless
+// the handler set them itself.
+func (s *Server) addDefaultHeaders(w *response.Writer, h headers.Headers) {
+	if !s.OmitDate && h.Get("Date") == "" {
+		h.Set("Date", response.Date())
+	}
+	if s.Name != "" && h.Get("Server") == "" {
+		h.Set("Server", s.Name)
+	}
+}
+
 type Handler func(w response.Writer, req *request.Request) *HandlerError
 
 type HandlerError struct {
@@ -83,8 +172,47 @@ type HandlerError struct {
 	Message string
 }
 
-// func (he *HandlerError) Error(w io.Writer) {
-// 	response.WriteStatusLine(w, he.Code)
-// 	response.WriteHeaders(w, response.GetDefaultHeaders(len(he.Message)))
-// 	response.WriteBody(w, []byte(he.Message))
-// }
+// NewHandlerError converts an error from one of the request body helpers
+// into a HandlerError carrying the matching status code. Any other error is
+// reported as a 500.
+func NewHandlerError(err error) *HandlerError {
+	var statusErr *request.StatusError
+	if errors.As(err, &statusErr) {
+		return &HandlerError{Code: response.StatusCode(statusErr.StatusCode), Message: statusErr.Message}
+	}
+	return &HandlerError{Code: 500, Message: err.Error()}
+}
+
+// errorContentTypes are the formats an error body can be rendered in, in
+// order of preference when the client doesn't say.
+var errorContentTypes = []string{"text/plain", "text/html", "application/json"}
+
+// Write sends the error as a response, with a body formatted as plain text,
+// HTML or JSON depending on the request's Accept header.
+func (he *HandlerError) Write(w response.Writer, req *request.Request) {
+	contentType := "text/plain"
+	if req != nil {
+		if negotiated, err := request.Negotiate(req, errorContentTypes); err == nil {
+			contentType = negotiated
+		}
+	}
+
+	var body []byte
+	switch contentType {
+	case "text/html":
+		title := strconv.Itoa(int(he.Code)) + " " + response.StatusText(he.Code)
+		body = []byte("<html>\n<head><title>" + html.EscapeString(title) + "</title></head>\n<body>\n<h1>" +
+			html.EscapeString(title) + "</h1>\n<p>" + html.EscapeString(he.Message) + "</p>\n</body>\n</html>\n")
+	case "application/json":
+		body, _ = json.Marshal(map[string]any{"status": he.Code, "error": he.Message})
+	default:
+		body = []byte(he.Message)
+	}
+
+	errorHeaders := response.GetDefaultHeaders(len(body))
+	errorHeaders["Content-Type"] = contentType + "; charset=utf-8"
+	errorHeaders["Vary"] = "Accept"
+	w.WriteStatusLine(he.Code)
+	w.WriteHeaders(errorHeaders)
+	w.WriteBody(body)
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jsleep/httpfromtcp/internal/request"
+	"github.com/jsleep/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHijack(t *testing.T) {
+	secondHijack := make(chan error, 1)
+	srv, err := Serve(0, func(w response.Writer, req *request.Request) *HandlerError {
+		conn, buffered, err := w.Hijack()
+		if err != nil {
+			return NewHandlerError(err)
+		}
+		_, _, err = w.Hijack()
+		secondHijack <- err
+
+		// keep using the connection after the handler has returned
+		go func() {
+			defer conn.Close()
+			conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n"))
+			lines := bufio.NewReader(io.MultiReader(bytes.NewReader(buffered), conn))
+			for {
+				line, err := lines.ReadString('\n')
+				if err != nil {
+					return
+				}
+				conn.Write([]byte("echo: " + line))
+			}
+		}()
+		return nil
+	})
+	require.NoError(t, err)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Test: lines sent along with the request reach the new protocol
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\nfirst\nsecond\n"))
+	require.NoError(t, err)
+	reader := bufio.NewReader(conn)
+	status, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols\r\n", status)
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			break
+		}
+	}
+	for _, want := range []string{"first", "second"} {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, "echo: "+want+"\n", line)
+	}
+
+	// Test: the server left the connection open for the handler's goroutine
+	_, err = conn.Write([]byte("third\n"))
+	require.NoError(t, err)
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "echo: third\n", line)
+
+	// Test: the connection can only be taken once
+	require.Error(t, <-secondHijack)
+}
+
+func TestRequestContext(t *testing.T) {
+	ended := make(chan error, 3)
+	handler := func(w response.Writer, req *request.Request) *HandlerError {
+		<-req.Context().Done()
+		ended <- req.Context().Err()
+		return nil
+	}
+	waitForEnd := func() error {
+		select {
+		case err := <-ended:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("handler wasn't canceled")
+			return nil
+		}
+	}
+
+	router := NewRouter()
+	router.Handle("GET", "/", handler)
+	router.Handle("GET", "/slow", Timeout(10*time.Millisecond, handler))
+	srv, err := Serve(0, router.Serve)
+	require.NoError(t, err)
+	defer srv.Close()
+
+	send := func(path string) net.Conn {
+		conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+		require.NoError(t, err)
+		_, err = conn.Write([]byte("GET " + path + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		require.NoError(t, err)
+		return conn
+	}
+
+	// Test: hanging up cancels the handler's context
+	conn := send("/")
+	time.Sleep(10 * time.Millisecond)
+	conn.Close()
+	assert.ErrorIs(t, waitForEnd(), context.Canceled)
+
+	// Test: a route with a deadline
+	conn = send("/slow")
+	defer conn.Close()
+	assert.ErrorIs(t, waitForEnd(), context.DeadlineExceeded)
+
+	// Test: closing the server cancels requests in flight
+	conn = send("/")
+	defer conn.Close()
+	time.Sleep(10 * time.Millisecond)
+	srv.Close()
+	assert.ErrorIs(t, waitForEnd(), context.Canceled)
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ServeTLS is Serve over TLS. The config must provide certificates, either
+// directly or through GetCertificate (see CertReloader and Certificates).
+func ServeTLS(port int, handler Handler, config *tls.Config) (*Server, error) {
+	server := &Server{Port: port}
+	if err := server.StartTLS(handler, config); err != nil {
+		return nil, err
+	}
+	return server, nil
+}
+
+// ServeTLSFiles is ServeTLS with a single certificate loaded from PEM
+// files, which is reloaded when either file changes.
+func ServeTLSFiles(port int, handler Handler, certFile string, keyFile string) (*Server, error) {
+	reloader, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return ServeTLS(port, handler, TLSConfig(reloader.GetCertificate))
+}
+
+// StartTLS is Start over TLS.
+func (s *Server) StartTLS(handler Handler, config *tls.Config) error {
+	if config == nil || (len(config.Certificates) == 0 && config.GetCertificate == nil && config.GetConfigForClient == nil) {
+		return errors.New("TLS config has no certificates")
+	}
+
+	ln, err := net.Listen("tcp", ":"+strconv.Itoa(s.Port))
+	if err != nil {
+		return err
+	}
+
+	s.serve(tls.NewListener(ln, config), handler)
+	return nil
+}
+
+// TLSConfig returns a config for serving HTTP/1.1 with certificates from
+// getCertificate.
+func TLSConfig(getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) *tls.Config {
+	return &tls.Config{
+		GetCertificate: getCertificate,
+		NextProtos:     []string{"http/1.1"},
+		MinVersion:     tls.VersionTLS12,
+	}
+}
+
+// CertReloader serves a certificate from PEM files and reloads it when
+// either file's modification time changes, so certificates can be renewed
+// without a restart. Files are checked at most once per CheckInterval,
+// during a handshake.
+type CertReloader struct {
+	CertFile      string
+	KeyFile       string
+	CheckInterval time.Duration
+
+	mu          sync.Mutex
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+	lastCheck   time.Time
+}
+
+func NewCertReloader(certFile string, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{CertFile: certFile, KeyFile: keyFile, CheckInterval: time.Second}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload loads the certificate from disk unconditionally.
+func (r *CertReloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reload()
+}
+
+func (r *CertReloader) reload() error {
+	certInfo, err := os.Stat(r.CertFile)
+	if err != nil {
+		return err
+	}
+	keyInfo, err := os.Stat(r.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.CertFile, r.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	r.cert = &cert
+	r.certModTime = certInfo.ModTime()
+	r.keyModTime = keyInfo.ModTime()
+	r.lastCheck = time.Now()
+	return nil
+}
+
+func (r *CertReloader) changed() bool {
+	certInfo, err := os.Stat(r.CertFile)
+	if err != nil {
+		return false
+	}
+	keyInfo, err := os.Stat(r.KeyFile)
+	if err != nil {
+		return false
+	}
+	return !certInfo.ModTime().Equal(r.certModTime) || !keyInfo.ModTime().Equal(r.keyModTime)
+}
+
+// GetCertificate is meant for tls.Config.GetCertificate.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.lastCheck) >= r.CheckInterval {
+		r.lastCheck = time.Now()
+		if r.changed() {
+			// a half-written pair fails to load, keep the old certificate
+			// and try again on the next check
+			if err := r.reload(); err != nil {
+				fmt.Println("Error reloading certificate:", err)
+			}
+		}
+	}
+	return r.cert, nil
+}
+
+// Certificates picks a certificate by the SNI server name in the client
+// hello. Hosts may be exact names or wildcards like "*.example.com".
+type Certificates struct {
+	mu    sync.RWMutex
+	hosts map[string]*CertReloader
+	// Default is used when nothing matches, including clients that don't
+	// send SNI. Without it those handshakes fail.
+	Default *CertReloader
+}
+
+func NewCertificates() *Certificates {
+	return &Certificates{hosts: map[string]*CertReloader{}}
+}
+
+// Add loads a certificate for host from PEM files, reloading it when they
+// change. The first certificate added becomes the default.
+func (c *Certificates) Add(host string, certFile string, keyFile string) error {
+	reloader, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hosts[strings.ToLower(host)] = reloader
+	if c.Default == nil {
+		c.Default = reloader
+	}
+	return nil
+}
+
+// GetCertificate is meant for tls.Config.GetCertificate.
+func (c *Certificates) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if reloader, ok := c.hosts[name]; ok {
+		return reloader.GetCertificate(hello)
+	}
+	// a wildcard covers exactly one label
+	if _, parent, found := strings.Cut(name, "."); found {
+		if reloader, ok := c.hosts["*."+parent]; ok {
+			return reloader.GetCertificate(hello)
+		}
+	}
+	if c.Default != nil {
+		return c.Default.GetCertificate(hello)
+	}
+	return nil, errors.New("no certificate for server name: " + hello.ServerName)
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jsleep/httpfromtcp/internal/request"
+	"github.com/jsleep/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCert generates a self-signed certificate for hosts and writes it and
+// its key as PEM files in dir.
+func writeCert(t *testing.T, dir string, name string, hosts ...string) (string, string, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hosts[0]},
+		DNSNames:              hosts,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certFile, keyFile, cert
+}
+
+func tlsHandler(w response.Writer, req *request.Request) *HandlerError {
+	body := "plaintext"
+	if req.TLS != nil {
+		body = req.TLS.ServerName + " " + req.TLS.NegotiatedProtocol
+	}
+	w.WriteStatusLine(200)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody([]byte(body))
+	return nil
+}
+
+// tlsGet makes a request over TLS and returns the certificate the server
+// presented and the response body.
+func tlsGet(t *testing.T, srv *Server, serverName string, roots *x509.CertPool) (*x509.Certificate, string) {
+	conn, err := tls.Dial("tcp", srv.Listener.Addr().String(), &tls.Config{
+		RootCAs:    roots,
+		ServerName: serverName,
+		NextProtos: []string{"http/1.1"},
+	})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: " + serverName + "\r\n\r\n"))
+	require.NoError(t, err)
+	resp, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	_, body, _ := strings.Cut(string(resp), "\r\n\r\n")
+	return conn.ConnectionState().PeerCertificates[0], body
+}
+
+func TestServeTLSFiles(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, cert := writeCert(t, dir, "server", "localhost")
+
+	srv, err := ServeTLSFiles(0, tlsHandler, certFile, keyFile)
+	require.NoError(t, err)
+	defer srv.Close()
+
+	// Test: the connection state reaches the handler
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	served, body := tlsGet(t, srv, "localhost", roots)
+	assert.Equal(t, cert.SerialNumber, served.SerialNumber)
+	assert.Equal(t, "localhost http/1.1", body)
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, cert := writeCert(t, dir, "server", "localhost")
+
+	reloader, err := NewCertReloader(certFile, keyFile)
+	require.NoError(t, err)
+	reloader.CheckInterval = 0
+	srv, err := ServeTLS(0, tlsHandler, TLSConfig(reloader.GetCertificate))
+	require.NoError(t, err)
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	served, _ := tlsGet(t, srv, "localhost", roots)
+	assert.Equal(t, cert.SerialNumber, served.SerialNumber)
+
+	// Test: replacing the files swaps the certificate without a restart
+	_, _, renewed := writeCert(t, dir, "server", "localhost")
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, future, future))
+	require.NoError(t, os.Chtimes(keyFile, future, future))
+	roots.AddCert(renewed)
+	served, _ = tlsGet(t, srv, "localhost", roots)
+	assert.Equal(t, renewed.SerialNumber, served.SerialNumber)
+}
+
+func TestSNI(t *testing.T) {
+	dir := t.TempDir()
+	certs := NewCertificates()
+	roots := x509.NewCertPool()
+
+	aCert, aKey, a := writeCert(t, dir, "a", "a.test")
+	require.NoError(t, certs.Add("a.test", aCert, aKey))
+	roots.AddCert(a)
+	wildCert, wildKey, wild := writeCert(t, dir, "wild", "*.b.test")
+	require.NoError(t, certs.Add("*.b.test", wildCert, wildKey))
+	roots.AddCert(wild)
+
+	srv, err := ServeTLS(0, tlsHandler, TLSConfig(certs.GetCertificate))
+	require.NoError(t, err)
+	defer srv.Close()
+
+	// Test: exact and wildcard names get their own certificate
+	served, body := tlsGet(t, srv, "a.test", roots)
+	assert.Equal(t, a.SerialNumber, served.SerialNumber)
+	assert.Equal(t, "a.test http/1.1", body)
+	served, _ = tlsGet(t, srv, "www.b.test", roots)
+	assert.Equal(t, wild.SerialNumber, served.SerialNumber)
+
+	// Test: unknown names fall back to the first certificate added
+	hello := &tls.ClientHelloInfo{ServerName: "unknown.test"}
+	fallback, err := certs.GetCertificate(hello)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(fallback.Certificate[0])
+	require.NoError(t, err)
+	assert.Equal(t, a.SerialNumber, leaf.SerialNumber)
+}
+
+func TestStartTLSWithoutCertificates(t *testing.T) {
+	_, err := ServeTLS(0, tlsHandler, &tls.Config{})
+	require.Error(t, err)
+}
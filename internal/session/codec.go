@@ -0,0 +1,136 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Key signs and optionally encrypts session cookies. HashKey is required
+// and should be at least 32 random bytes. BlockKey enables AES-GCM
+// encryption and must be 16, 24 or 32 bytes long.
+type Key struct {
+	HashKey  []byte
+	BlockKey []byte
+}
+
+var (
+	ErrInvalidCookie = errors.New("session cookie failed verification")
+	ErrExpired       = errors.New("session cookie expired")
+)
+
+// payload is what ends up inside the cookie: either the session values
+// themselves or, when a server-side store is used, only the session ID.
+type payload struct {
+	Expires int64          `json:"e"`
+	ID      string         `json:"id,omitempty"`
+	Values  map[string]any `json:"v,omitempty"`
+}
+
+func (k Key) mac(name string, data []byte) []byte {
+	h := hmac.New(sha256.New, k.HashKey)
+	// bind the signature to the cookie name so a value can't be replayed
+	// under a different cookie
+	h.Write([]byte(name + "|"))
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func (k Key) encrypt(data []byte) ([]byte, error) {
+	if len(k.BlockKey) == 0 {
+		return data, nil
+	}
+	gcm, err := newGCM(k.BlockKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, data, nil), nil
+}
+
+func (k Key) decrypt(data []byte) ([]byte, error) {
+	if len(k.BlockKey) == 0 {
+		return data, nil
+	}
+	gcm, err := newGCM(k.BlockKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, ErrInvalidCookie
+	}
+	nonce, sealed := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, sealed, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encode serializes p with the first (current) key into
+// base64(data) + "." + base64(mac).
+func encode(name string, keys []Key, p payload) (string, error) {
+	if len(keys) == 0 {
+		return "", errors.New("session: no keys configured")
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	data, err = keys[0].encrypt(data)
+	if err != nil {
+		return "", err
+	}
+	mac := keys[0].mac(name, data)
+	return base64.RawURLEncoding.EncodeToString(data) + "." + base64.RawURLEncoding.EncodeToString(mac), nil
+}
+
+// decode verifies value against every key in turn so cookies signed with a
+// retired key stay valid until they're rewritten with the current one.
+func decode(name string, keys []Key, value string, now time.Time) (payload, error) {
+	var p payload
+	encodedData, encodedMac, found := strings.Cut(value, ".")
+	if !found {
+		return p, ErrInvalidCookie
+	}
+	data, err := base64.RawURLEncoding.DecodeString(encodedData)
+	if err != nil {
+		return p, ErrInvalidCookie
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(encodedMac)
+	if err != nil {
+		return p, ErrInvalidCookie
+	}
+
+	for _, key := range keys {
+		if !hmac.Equal(mac, key.mac(name, data)) {
+			continue
+		}
+		plain, err := key.decrypt(data)
+		if err != nil {
+			return p, ErrInvalidCookie
+		}
+		if err := json.Unmarshal(plain, &p); err != nil {
+			return p, ErrInvalidCookie
+		}
+		if p.Expires != 0 && now.Unix() > p.Expires {
+			return p, ErrExpired
+		}
+		return p, nil
+	}
+	return p, ErrInvalidCookie
+}
@@ -0,0 +1,203 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/jsleep/httpfromtcp/internal/cookie"
+	"github.com/jsleep/httpfromtcp/internal/headers"
+	"github.com/jsleep/httpfromtcp/internal/request"
+	"github.com/jsleep/httpfromtcp/internal/response"
+	"github.com/jsleep/httpfromtcp/internal/server"
+)
+
+// maxCookieSize is the largest cookie browsers are required to accept.
+const maxCookieSize = 4096
+
+// Session holds the values for one client. Values are serialized as JSON,
+// so numbers come back as float64 and structs as map[string]any.
+type Session struct {
+	ID        string
+	values    map[string]any
+	modified  bool
+	destroyed bool
+}
+
+func (s *Session) Get(key string) any {
+	return s.values[key]
+}
+
+func (s *Session) Set(key string, value any) {
+	s.values[key] = value
+	s.modified = true
+}
+
+func (s *Session) Delete(key string) {
+	delete(s.values, key)
+	s.modified = true
+}
+
+// Destroy clears the session and expires its cookie.
+func (s *Session) Destroy() {
+	s.values = map[string]any{}
+	s.destroyed = true
+}
+
+// Manager loads and saves sessions around a server.Handler.
+type Manager struct {
+	CookieName string
+	Path       string
+	Domain     string
+	Secure     bool
+	SameSite   cookie.SameSite
+	MaxAge     time.Duration
+
+	// Keys are tried in order when verifying a cookie and the first one is
+	// used to sign new cookies. Rotate by prepending a new key and dropping
+	// the oldest once its cookies have expired.
+	Keys []Key
+
+	// Store keeps values server-side when set. Otherwise the values are
+	// stored in the cookie itself.
+	Store Store
+}
+
+// sessionKey keys a manager's session in the request context, so several
+// managers can be used side by side.
+type sessionKey struct {
+	m *Manager
+}
+
+func NewManager(keys ...Key) *Manager {
+	return &Manager{
+		CookieName: "session",
+		Path:       "/",
+		SameSite:   cookie.SameSiteLax,
+		MaxAge:     24 * time.Hour,
+		Keys:       keys,
+	}
+}
+
+// Get returns the session for a request being handled by Middleware, or nil
+// if the request didn't go through it.
+func (m *Manager) Get(req *request.Request) *Session {
+	s, _ := req.Context().Value(sessionKey{m}).(*Session)
+	return s
+}
+
+// Middleware loads the session before next runs and writes it back as a
+// cookie just before next writes its headers.
+func (m *Manager) Middleware(next server.Handler) server.Handler {
+	return func(w response.Writer, req *request.Request) *server.HandlerError {
+		s := m.load(req)
+		req = req.WithContext(context.WithValue(req.Context(), sessionKey{m}, s))
+
+		w.OnWriteHeaders(func(w *response.Writer, _ headers.Headers) {
+			if err := m.save(w, s); err != nil {
+				fmt.Println("Error saving session:", err)
+			}
+		})
+
+		return next(w, req)
+	}
+}
+
+func (m *Manager) load(req *request.Request) *Session {
+	s := &Session{values: map[string]any{}}
+
+	value, ok := req.Cookie(m.CookieName)
+	if !ok {
+		return s
+	}
+
+	p, err := decode(m.CookieName, m.Keys, value, time.Now())
+	if err != nil {
+		// a tampered or expired cookie just starts a new session
+		return s
+	}
+
+	if m.Store == nil {
+		if p.Values != nil {
+			s.values = p.Values
+		}
+		return s
+	}
+
+	values, found, err := m.Store.Load(p.ID)
+	if err != nil {
+		fmt.Println("Error loading session:", err)
+		return s
+	}
+	if found {
+		s.ID = p.ID
+		s.values = values
+	}
+	return s
+}
+
+func (m *Manager) save(w *response.Writer, s *Session) error {
+	if s.destroyed {
+		if m.Store != nil && s.ID != "" {
+			if err := m.Store.Delete(s.ID); err != nil {
+				return err
+			}
+		}
+		return w.SetCookie(m.cookie("", -1))
+	}
+
+	if !s.modified {
+		return nil
+	}
+
+	expires := time.Now().Add(m.MaxAge)
+	p := payload{Expires: expires.Unix()}
+
+	if m.Store != nil {
+		if s.ID == "" {
+			id, err := newID()
+			if err != nil {
+				return err
+			}
+			s.ID = id
+		}
+		if err := m.Store.Save(s.ID, s.values, expires); err != nil {
+			return err
+		}
+		p.ID = s.ID
+	} else {
+		p.Values = s.values
+	}
+
+	value, err := encode(m.CookieName, m.Keys, p)
+	if err != nil {
+		return err
+	}
+	if len(m.CookieName)+len(value) > maxCookieSize {
+		return fmt.Errorf("session cookie is %d bytes, over the %d byte limit", len(value), maxCookieSize)
+	}
+	return w.SetCookie(m.cookie(value, int(m.MaxAge.Seconds())))
+}
+
+func (m *Manager) cookie(value string, maxAge int) *cookie.Cookie {
+	return &cookie.Cookie{
+		Name:     m.CookieName,
+		Value:    value,
+		Path:     m.Path,
+		Domain:   m.Domain,
+		MaxAge:   maxAge,
+		Secure:   m.Secure,
+		HttpOnly: true,
+		SameSite: m.SameSite,
+	}
+}
+
+func newID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
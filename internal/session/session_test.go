@@ -0,0 +1,152 @@
+package session
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jsleep/httpfromtcp/internal/request"
+	"github.com/jsleep/httpfromtcp/internal/response"
+	"github.com/jsleep/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	hashKey  = []byte("0123456789abcdef0123456789abcdef")
+	blockKey = []byte("abcdef0123456789")
+)
+
+// serve runs handler behind m's middleware and returns the session cookie
+// value it set, if any.
+func serve(t *testing.T, m *Manager, cookieValue string, handler server.Handler) string {
+	value, _, _ := strings.Cut(setCookie(t, m, cookieValue, handler), ";")
+	value, _ = strings.CutPrefix(value, m.CookieName+"=")
+	return value
+}
+
+// setCookie runs handler behind m's middleware and returns the whole
+// Set-Cookie header it sent for the session, if any.
+func setCookie(t *testing.T, m *Manager, cookieValue string, handler server.Handler) string {
+	raw := "GET / HTTP/1.1\r\n"
+	if cookieValue != "" {
+		raw += "Cookie: " + m.CookieName + "=" + cookieValue + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	w := response.Writer{Writer: &buf}
+	require.Nil(t, m.Middleware(handler)(w, req))
+
+	for _, line := range strings.Split(buf.String(), "\r\n") {
+		if value, found := strings.CutPrefix(line, "Set-Cookie: "); found && strings.HasPrefix(value, m.CookieName+"=") {
+			return value
+		}
+	}
+	return ""
+}
+
+func respond(w response.Writer) {
+	w.WriteStatusLine(200)
+	w.WriteHeaders(response.GetDefaultHeaders(0))
+}
+
+func TestCookieSession(t *testing.T) {
+	for _, key := range []Key{{HashKey: hashKey}, {HashKey: hashKey, BlockKey: blockKey}} {
+		m := NewManager(key)
+
+		// Test: setting a value issues a cookie
+		value := serve(t, m, "", func(w response.Writer, req *request.Request) *server.HandlerError {
+			m.Get(req).Set("user", "gopher")
+			respond(w)
+			return nil
+		})
+		require.NotEmpty(t, value)
+		if key.BlockKey != nil {
+			assert.NotContains(t, value, "gopher")
+		}
+
+		// Test: the cookie round trips and an unmodified session isn't rewritten
+		var user any
+		again := serve(t, m, value, func(w response.Writer, req *request.Request) *server.HandlerError {
+			user = m.Get(req).Get("user")
+			respond(w)
+			return nil
+		})
+		assert.Equal(t, "gopher", user)
+		assert.Empty(t, again)
+
+		// Test: a tampered cookie is ignored
+		tampered := "x" + value[1:]
+		serve(t, m, tampered, func(w response.Writer, req *request.Request) *server.HandlerError {
+			user = m.Get(req).Get("user")
+			respond(w)
+			return nil
+		})
+		assert.Nil(t, user)
+	}
+}
+
+func TestKeyRotationAndExpiry(t *testing.T) {
+	oldKey := Key{HashKey: []byte("old-key-old-key-old-key-old-key!")}
+	value, err := encode("session", []Key{oldKey}, payload{Expires: time.Now().Add(time.Hour).Unix(), Values: map[string]any{"n": 1}})
+	require.NoError(t, err)
+
+	// Test: cookies signed with an older key still verify
+	m := NewManager(Key{HashKey: hashKey}, oldKey)
+	var n any
+	serve(t, m, value, func(w response.Writer, req *request.Request) *server.HandlerError {
+		n = m.Get(req).Get("n")
+		respond(w)
+		return nil
+	})
+	assert.Equal(t, float64(1), n)
+
+	// Test: once the key is dropped they don't
+	_, err = decode("session", []Key{{HashKey: hashKey}}, value, time.Now())
+	require.ErrorIs(t, err, ErrInvalidCookie)
+
+	// Test: expired payloads are rejected
+	_, err = decode("session", []Key{oldKey}, value, time.Now().Add(2*time.Hour))
+	require.ErrorIs(t, err, ErrExpired)
+
+	// Test: a cookie can't be replayed under another name
+	_, err = decode("other", []Key{oldKey}, value, time.Now())
+	require.ErrorIs(t, err, ErrInvalidCookie)
+}
+
+func TestStoreSession(t *testing.T) {
+	m := NewManager(Key{HashKey: hashKey})
+	store := NewMemoryStore()
+	m.Store = store
+
+	// Test: values live in the store and the cookie only has the ID
+	var id string
+	value := serve(t, m, "", func(w response.Writer, req *request.Request) *server.HandlerError {
+		s := m.Get(req)
+		s.Set("cart", "3 items")
+		respond(w)
+		id = s.ID
+		return nil
+	})
+	require.NotEmpty(t, id)
+	assert.NotContains(t, value, "items")
+	values, ok, err := store.Load(id)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "3 items", values["cart"])
+
+	// Test: destroying the session deletes it and expires the cookie
+	expired := setCookie(t, m, value, func(w response.Writer, req *request.Request) *server.HandlerError {
+		assert.Equal(t, "3 items", m.Get(req).Get("cart"))
+		m.Get(req).Destroy()
+		respond(w)
+		return nil
+	})
+	assert.True(t, strings.HasPrefix(expired, m.CookieName+"=;"), expired)
+	assert.Contains(t, expired, "; Max-Age=0")
+	_, ok, _ = store.Load(id)
+	assert.False(t, ok)
+}
@@ -0,0 +1,86 @@
+package session
+
+import (
+	"sync"
+	"time"
+)
+
+// Store keeps session values on the server. When a Manager has a Store the
+// cookie only carries the signed session ID.
+type Store interface {
+	// Load returns the values saved for id, or ok == false if there are none
+	// or they have expired.
+	Load(id string) (values map[string]any, ok bool, err error)
+	Save(id string, values map[string]any, expires time.Time) error
+	Delete(id string) error
+}
+
+type memoryEntry struct {
+	values  map[string]any
+	expires time.Time
+}
+
+// MemoryStore is a Store backed by a map. Sessions are lost when the
+// process exits.
+type MemoryStore struct {
+	mu       sync.Mutex
+	sessions map[string]memoryEntry
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sessions: map[string]memoryEntry{}}
+}
+
+func (s *MemoryStore) Load(id string) (map[string]any, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.sessions[id]
+	if !ok {
+		return nil, false, nil
+	}
+	if time.Now().After(entry.expires) {
+		delete(s.sessions, id)
+		return nil, false, nil
+	}
+
+	values := make(map[string]any, len(entry.values))
+	for k, v := range entry.values {
+		values[k] = v
+	}
+	return values, true, nil
+}
+
+func (s *MemoryStore) Save(id string, values map[string]any, expires time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	copied := make(map[string]any, len(values))
+	for k, v := range values {
+		copied[k] = v
+	}
+	s.sessions[id] = memoryEntry{values: copied, expires: expires}
+	return nil
+}
+
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, id)
+	return nil
+}
+
+// Cleanup drops expired sessions. Expired entries are also dropped lazily
+// on Load, so calling this is only needed to bound memory use.
+func (s *MemoryStore) Cleanup() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, entry := range s.sessions {
+		if now.After(entry.expires) {
+			delete(s.sessions, id)
+		}
+	}
+}
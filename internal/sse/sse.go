@@ -0,0 +1,287 @@
+package sse
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jsleep/httpfromtcp/internal/headers"
+	"github.com/jsleep/httpfromtcp/internal/request"
+	"github.com/jsleep/httpfromtcp/internal/response"
+)
+
+// DefaultHeartbeat is how often a Stream sends a comment when it has
+// nothing else to say, so proxies keep the connection open and a client
+// that went away is noticed.
+var DefaultHeartbeat = 15 * time.Second
+
+// Event is one server-sent event. Only Data is dispatched to the client's
+// handler; ID becomes its last event ID and Retry its reconnection delay.
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+	Retry time.Duration
+}
+
+// format renders the event in the text/event-stream format, ending with
+// the blank line that dispatches it.
+func (e Event) format() (string, error) {
+	if strings.ContainsAny(e.ID, "\r\n\x00") {
+		return "", errors.New("event ID can't contain newlines or NUL")
+	}
+	if strings.ContainsAny(e.Event, "\r\n") {
+		return "", errors.New("event name can't contain newlines")
+	}
+
+	var b strings.Builder
+	if e.ID != "" {
+		b.WriteString("id: " + e.ID + "\n")
+	}
+	if e.Event != "" {
+		b.WriteString("event: " + e.Event + "\n")
+	}
+	if e.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(e.Retry.Milliseconds(), 10) + "\n")
+	}
+	if e.Data != "" || e.Event != "" {
+		// every line of the data gets its own field, the client joins them
+		// back together with "\n"
+		data := strings.ReplaceAll(e.Data, "\r\n", "\n")
+		data = strings.ReplaceAll(data, "\r", "\n")
+		for _, line := range strings.Split(data, "\n") {
+			b.WriteString("data: " + line + "\n")
+		}
+	}
+	b.WriteString("\n")
+	return b.String(), nil
+}
+
+// Stream sends events over a chunked text/event-stream response. Send is
+// safe to call from several goroutines.
+type Stream struct {
+	w           *response.Writer
+	lastEventID string
+
+	mu     sync.Mutex
+	err    error
+	done   chan struct{}
+	closed bool
+}
+
+// NewStream writes the response headers and starts sending a heartbeat
+// every DefaultHeartbeat.
+func NewStream(w *response.Writer, req *request.Request) (*Stream, error) {
+	return NewStreamHeartbeat(w, req, DefaultHeartbeat)
+}
+
+// NewStreamHeartbeat is NewStream with its own heartbeat interval. An
+// interval of 0 turns heartbeats off.
+func NewStreamHeartbeat(w *response.Writer, req *request.Request, heartbeat time.Duration) (*Stream, error) {
+	s := &Stream{
+		w:           w,
+		lastEventID: req.Headers.Get("Last-Event-ID"),
+		done:        make(chan struct{}),
+	}
+
+	if err := w.WriteStatusLine(200); err != nil {
+		return nil, err
+	}
+	err := w.WriteHeaders(headers.Headers{
+		"Content-Type":      "text/event-stream; charset=utf-8",
+		"Cache-Control":     "no-cache",
+		"Transfer-Encoding": "chunked",
+		"Connection":        "close",
+		// stop nginx and friends from buffering the stream
+		"X-Accel-Buffering": "no",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if w.BodyDiscarded() {
+		// a HEAD request, there's nothing to stream
+		s.end(nil)
+		return s, nil
+	}
+
+	go s.watch(req.Context())
+	if heartbeat > 0 {
+		go s.heartbeat(heartbeat)
+	}
+	return s, nil
+}
+
+// watch ends the stream when the request's context is done, which happens
+// as soon as the server notices the client hang up.
+func (s *Stream) watch(ctx context.Context) {
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.end(ctx.Err())
+	}
+}
+
+// end marks the stream closed. The caller holds mu.
+func (s *Stream) end(err error) {
+	if s.closed {
+		return
+	}
+	s.err = err
+	s.closed = true
+	close(s.done)
+}
+
+// LastEventID is the ID of the last event a reconnecting client saw, from
+// its Last-Event-ID header. It's empty on a first connection.
+func (s *Stream) LastEventID() string {
+	return s.lastEventID
+}
+
+// Done is closed once the stream can't be written to anymore, because the
+// client disconnected, the request's context was canceled or Close was
+// called. Handlers should stop then.
+func (s *Stream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the write or context error that ended the stream, if any.
+func (s *Stream) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Send writes an event and flushes it to the client.
+func (s *Stream) Send(e Event) error {
+	text, err := e.format()
+	if err != nil {
+		return err
+	}
+	return s.write(text)
+}
+
+// Comment sends a comment line, which clients ignore.
+func (s *Stream) Comment(text string) error {
+	var b strings.Builder
+	for _, line := range strings.Split(text, "\n") {
+		b.WriteString(":" + line + "\n")
+	}
+	b.WriteString("\n")
+	return s.write(b.String())
+}
+
+// Replay sends the events in h the client missed since its Last-Event-ID.
+func (s *Stream) Replay(h *History) error {
+	for _, e := range h.Since(s.lastEventID) {
+		if err := s.Send(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Stream) write(text string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		if s.err != nil {
+			return s.err
+		}
+		return errors.New("stream closed")
+	}
+
+	_, err := s.w.WriteChunkedBody([]byte(text))
+	if err == nil {
+		err = s.w.Flush()
+	}
+	if err != nil {
+		// writes only fail once the client is gone
+		s.end(err)
+	}
+	return err
+}
+
+func (s *Stream) heartbeat(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if err := s.Comment(""); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Close ends the response. The client will reconnect after its retry
+// delay unless it's told otherwise, e.g. by an event it understands.
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return s.err
+	}
+	s.end(nil)
+
+	if _, err := s.w.WriteChunkedBodyDone(); err != nil {
+		return err
+	}
+	return s.w.WriteTrailers(headers.NewHeaders())
+}
+
+// History keeps the most recent events so a reconnecting client can be
+// sent what it missed.
+type History struct {
+	mu     sync.Mutex
+	size   int
+	events []Event
+	nextID int
+}
+
+func NewHistory(size int) *History {
+	return &History{size: size, nextID: 1}
+}
+
+// Add records e, giving it the next sequential ID if it has none, and
+// returns it as recorded.
+func (h *History) Add(e Event) Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if e.ID == "" {
+		e.ID = strconv.Itoa(h.nextID)
+		h.nextID++
+	}
+	h.events = append(h.events, e)
+	if len(h.events) > h.size {
+		h.events = h.events[len(h.events)-h.size:]
+	}
+	return e
+}
+
+// Since returns the events recorded after the one with id. An empty id
+// means a new client, which gets nothing; an id that's no longer kept gets
+// everything there is.
+func (h *History) Since(id string) []Event {
+	if id == "" {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, e := range h.events {
+		if e.ID == id {
+			return append([]Event(nil), h.events[i+1:]...)
+		}
+	}
+	return append([]Event(nil), h.events...)
+}
@@ -0,0 +1,164 @@
+package sse
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jsleep/httpfromtcp/internal/request"
+	"github.com/jsleep/httpfromtcp/internal/response"
+	"github.com/jsleep/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormat(t *testing.T) {
+	// Test: every field, with multi-line data split into data fields
+	text, err := Event{ID: "7", Event: "update", Data: "line one\r\nline two\nline three", Retry: 3 * time.Second}.format()
+	require.NoError(t, err)
+	assert.Equal(t, "id: 7\nevent: update\nretry: 3000\ndata: line one\ndata: line two\ndata: line three\n\n", text)
+
+	// Test: just data
+	text, err = Event{Data: "hi"}.format()
+	require.NoError(t, err)
+	assert.Equal(t, "data: hi\n\n", text)
+
+	// Test: a newline would end the field early
+	_, err = Event{ID: "1\n2", Data: "x"}.format()
+	require.Error(t, err)
+}
+
+func TestHistory(t *testing.T) {
+	h := NewHistory(3)
+	for _, data := range []string{"a", "b", "c", "d"} {
+		h.Add(Event{Data: data})
+	}
+
+	// Test: events after the last one the client saw
+	since := h.Since("3")
+	require.Len(t, since, 1)
+	assert.Equal(t, "d", since[0].Data)
+
+	// Test: everything kept when the ID has been dropped, nothing for new clients
+	assert.Len(t, h.Since("1"), 3)
+	assert.Empty(t, h.Since(""))
+}
+
+// startStream serves handler and returns a reader positioned at the start
+// of the response body.
+func startStream(t *testing.T, handler server.Handler, extra string) (net.Conn, *bufio.Reader, string) {
+	srv, err := server.Serve(0, handler)
+	require.NoError(t, err)
+	t.Cleanup(func() { srv.Close() })
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	_, err = conn.Write([]byte("GET /events HTTP/1.1\r\nHost: localhost\r\n" + extra + "\r\n"))
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(conn)
+	var head strings.Builder
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			break
+		}
+		head.WriteString(line)
+	}
+	return conn, reader, head.String()
+}
+
+// readChunk reads one chunk of a chunked body.
+func readChunk(t *testing.T, reader *bufio.Reader) string {
+	size, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	n, err := strconv.ParseInt(strings.TrimSpace(size), 16, 64)
+	require.NoError(t, err)
+	chunk := make([]byte, n+2) // +2 for the \r\n after it
+	_, err = io.ReadFull(reader, chunk)
+	require.NoError(t, err)
+	return string(chunk[:n])
+}
+
+func TestStream(t *testing.T) {
+	history := NewHistory(10)
+	history.Add(Event{Data: "first"})
+	history.Add(Event{Data: "second"})
+
+	handler := func(w response.Writer, req *request.Request) *server.HandlerError {
+		stream, err := NewStreamHeartbeat(&w, req, 0)
+		if err != nil {
+			return server.NewHandlerError(err)
+		}
+		if err := stream.Replay(history); err != nil {
+			return nil
+		}
+		stream.Send(Event{Event: "done", Data: "bye"})
+		stream.Close()
+		return nil
+	}
+
+	// Test: headers, and a reconnecting client gets what it missed
+	_, reader, head := startStream(t, handler, "Last-Event-ID: 1\r\n")
+	assert.Contains(t, head, "Content-Type: text/event-stream; charset=utf-8\r\n")
+	assert.Contains(t, head, "Cache-Control: no-cache\r\n")
+	assert.Contains(t, head, "Transfer-Encoding: chunked\r\n")
+	assert.Equal(t, "id: 2\ndata: second\n\n", readChunk(t, reader))
+	assert.Equal(t, "event: done\ndata: bye\n\n", readChunk(t, reader))
+	assert.Equal(t, "", readChunk(t, reader))
+}
+
+func TestHeartbeatAndDisconnect(t *testing.T) {
+	stopped := make(chan struct{})
+	handler := func(w response.Writer, req *request.Request) *server.HandlerError {
+		defer close(stopped)
+		stream, err := NewStreamHeartbeat(&w, req, 10*time.Millisecond)
+		if err != nil {
+			return server.NewHandlerError(err)
+		}
+		<-stream.Done()
+		return nil
+	}
+
+	// Test: comments keep the connection busy while nothing happens
+	conn, reader, _ := startStream(t, handler, "")
+	assert.Equal(t, ":\n\n", readChunk(t, reader))
+
+	// Test: the handler is told when the client goes away
+	conn.Close()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler didn't notice the client disconnecting")
+	}
+}
+
+func TestDisconnectWithoutHeartbeat(t *testing.T) {
+	ended := make(chan error, 1)
+	handler := func(w response.Writer, req *request.Request) *server.HandlerError {
+		stream, err := NewStreamHeartbeat(&w, req, 0)
+		if err != nil {
+			return server.NewHandlerError(err)
+		}
+		<-stream.Done()
+		ended <- stream.Err()
+		return nil
+	}
+
+	// Test: with nothing being written, the request context gives it away
+	conn, _, _ := startStream(t, handler, "")
+	conn.Close()
+	select {
+	case err := <-ended:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler didn't notice the client disconnecting")
+	}
+}
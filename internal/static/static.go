@@ -0,0 +1,268 @@
+package static
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/jsleep/httpfromtcp/internal/headers"
+	"github.com/jsleep/httpfromtcp/internal/request"
+	"github.com/jsleep/httpfromtcp/internal/response"
+	"github.com/jsleep/httpfromtcp/internal/server"
+)
+
+// Handler serves files from Root. It works with any fs.FS, including
+// os.DirFS and embed.FS.
+type Handler struct {
+	Root fs.FS
+
+	// Prefix is stripped from the request path before it's looked up in
+	// Root, e.g. "/assets" to serve Root at /assets/.
+	Prefix string
+
+	// IndexFile is served for a directory when present. Empty disables it.
+	IndexFile string
+
+	// ListDirectories renders an HTML listing for directories without an
+	// index file. Otherwise they're a 403.
+	ListDirectories bool
+
+	// FollowSymlinks allows paths that go through a symbolic link. It only
+	// has an effect when Root can report links, like DirFS does.
+	FollowSymlinks bool
+}
+
+// FileServer returns a handler serving root with index.html support and no
+// directory listings.
+func FileServer(root fs.FS) server.Handler {
+	h := &Handler{Root: root, IndexFile: "index.html"}
+	return h.Serve
+}
+
+// LstatFS is implemented by file systems that can report symbolic links
+// instead of following them.
+type LstatFS interface {
+	fs.FS
+	Lstat(name string) (fs.FileInfo, error)
+}
+
+type dirFS struct {
+	fs.FS
+	dir string
+}
+
+// DirFS is os.DirFS plus Lstat, so a Handler can enforce FollowSymlinks.
+func DirFS(dir string) fs.FS {
+	return dirFS{FS: os.DirFS(dir), dir: dir}
+}
+
+func (d dirFS) Lstat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: fs.ErrInvalid}
+	}
+	return os.Lstat(d.dir + "/" + name)
+}
+
+var errSymlink = errors.New("path contains a symbolic link")
+
+// resolve turns a request path into a name inside Root. It returns an
+// error for anything that could escape Root.
+func (h *Handler) resolve(requestPath string) (string, error) {
+	decoded, err := url.PathUnescape(requestPath)
+	if err != nil {
+		return "", err
+	}
+	if strings.ContainsRune(decoded, 0) || strings.Contains(decoded, "\\") {
+		return "", errors.New("invalid path: " + requestPath)
+	}
+
+	trimmed, found := strings.CutPrefix(decoded, h.Prefix)
+	if !found || (trimmed != "" && !strings.HasPrefix(trimmed, "/")) {
+		return "", fs.ErrNotExist
+	}
+
+	// Clean against "/" so ".." can never climb above the root
+	name := strings.TrimPrefix(path.Clean("/"+trimmed), "/")
+	if name == "" {
+		name = "."
+	}
+	if !fs.ValidPath(name) {
+		return "", errors.New("invalid path: " + requestPath)
+	}
+	return name, nil
+}
+
+// checkSymlinks walks each element of name and rejects symbolic links
+// unless FollowSymlinks is set.
+func (h *Handler) checkSymlinks(name string) error {
+	lstatFS, ok := h.Root.(LstatFS)
+	if h.FollowSymlinks || !ok || name == "." {
+		return nil
+	}
+	current := ""
+	for _, part := range strings.Split(name, "/") {
+		current = path.Join(current, part)
+		info, err := lstatFS.Lstat(current)
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return errSymlink
+		}
+	}
+	return nil
+}
+
+func (h *Handler) Serve(w response.Writer, req *request.Request) *server.HandlerError {
+	method := req.RequestLine.Method
+	if method != "GET" && method != "HEAD" {
+		return &server.HandlerError{Code: 405, Message: "Method Not Allowed"}
+	}
+
+	name, err := h.resolve(req.Path())
+	if errors.Is(err, fs.ErrNotExist) {
+		return &server.HandlerError{Code: 404, Message: "Not Found"}
+	}
+	if err != nil {
+		return &server.HandlerError{Code: 400, Message: "Bad Request"}
+	}
+	if err := h.checkSymlinks(name); err != nil {
+		if errors.Is(err, errSymlink) {
+			return &server.HandlerError{Code: 403, Message: "Forbidden"}
+		}
+		return &server.HandlerError{Code: 404, Message: "Not Found"}
+	}
+
+	info, err := fs.Stat(h.Root, name)
+	if err != nil {
+		return &server.HandlerError{Code: 404, Message: "Not Found"}
+	}
+
+	if info.IsDir() {
+		// relative links in an index or listing need the trailing slash
+		if !strings.HasSuffix(req.Path(), "/") {
+			redirect(&w, h.directoryLocation(name, req))
+			return nil
+		}
+		if h.IndexFile != "" {
+			index := path.Join(name, h.IndexFile)
+			if indexInfo, err := fs.Stat(h.Root, index); err == nil && !indexInfo.IsDir() && h.checkSymlinks(index) == nil {
+				return h.serveFile(w, req, index, indexInfo)
+			}
+		}
+		if !h.ListDirectories {
+			return &server.HandlerError{Code: 403, Message: "Forbidden"}
+		}
+		return h.serveListing(w, name, req.Path())
+	}
+
+	return h.serveFile(w, req, name, info)
+}
+
+func (h *Handler) serveFile(w response.Writer, req *request.Request, name string, info fs.FileInfo) *server.HandlerError {
+	f, err := h.Root.Open(name)
+	if err != nil {
+		return &server.HandlerError{Code: 404, Message: "Not Found"}
+	}
+	defer f.Close()
+
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		// not every fs.FS hands out seekable files, fall back to memory
+		data, err := io.ReadAll(f)
+		if err != nil {
+			return &server.HandlerError{Code: 500, Message: "Internal Server Error"}
+		}
+		content = bytes.NewReader(data)
+	}
+
+	etag, err := fileETag(info, content)
+	if err != nil {
+		return &server.HandlerError{Code: 500, Message: "Internal Server Error"}
+	}
+
+	extra := headers.Headers{"ETag": etag}
+	if err := response.ServeContent(&w, req, info.Name(), info.ModTime(), content, extra); err != nil {
+		// the response may already be partly written, all we can do is log it
+		fmt.Println("Error serving file:", err)
+	}
+	return nil
+}
+
+// fileETag uses size and modification time when there is one. Files from an
+// embed.FS have no modification time, so their contents are hashed instead.
+func fileETag(info fs.FileInfo, content io.ReadSeeker) (string, error) {
+	if !info.ModTime().IsZero() {
+		return response.WeakETag(info.Size(), info.ModTime()), nil
+	}
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return response.StrongETag(data), nil
+}
+
+func (h *Handler) serveListing(w response.Writer, name string, requestPath string) *server.HandlerError {
+	entries, err := fs.ReadDir(h.Root, name)
+	if err != nil {
+		return &server.HandlerError{Code: 500, Message: "Internal Server Error"}
+	}
+
+	var body strings.Builder
+	title := html.EscapeString(requestPath)
+	body.WriteString("<html>\n<head><title>Index of " + title + "</title></head>\n<body>\n<h1>Index of " + title + "</h1>\n<ul>\n")
+	if name != "." {
+		body.WriteString("<li><a href=\"../\">../</a></li>\n")
+	}
+	for _, entry := range entries {
+		entryName := entry.Name()
+		if entry.IsDir() {
+			entryName += "/"
+		}
+		href := (&url.URL{Path: entryName}).String()
+		body.WriteString("<li><a href=\"" + html.EscapeString(href) + "\">" + html.EscapeString(entryName) + "</a></li>\n")
+	}
+	body.WriteString("</ul>\n</body>\n</html>\n")
+
+	listingHeaders := response.GetDefaultHeaders(body.Len())
+	listingHeaders["Content-Type"] = "text/html; charset=utf-8"
+	w.WriteStatusLine(200)
+	w.WriteHeaders(listingHeaders)
+	w.WriteBody([]byte(body.String()))
+	return nil
+}
+
+// directoryLocation is where a request for the directory name, which
+// lacked the trailing slash, is sent. It's built from the cleaned name, not
+// the request path, so "//evil.example/.." can't turn into a redirect to
+// another host, and it keeps the query.
+func (h *Handler) directoryLocation(name string, req *request.Request) string {
+	dir := h.Prefix + "/"
+	if name != "." {
+		dir += name + "/"
+	}
+	location := (&url.URL{Path: dir}).EscapedPath()
+	// a leading "//" would be read as a host
+	location = "/" + strings.TrimLeft(location, "/")
+	if _, query, found := strings.Cut(req.OriginTarget(), "?"); found && query != "" {
+		location += "?" + query
+	}
+	return location
+}
+
+func redirect(w *response.Writer, location string) {
+	redirectHeaders := response.GetDefaultHeaders(0)
+	redirectHeaders["Location"] = location
+	w.WriteStatusLine(301)
+	w.WriteHeaders(redirectHeaders)
+}
@@ -0,0 +1,147 @@
+package static
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jsleep/httpfromtcp/internal/request"
+	"github.com/jsleep/httpfromtcp/internal/response"
+	"github.com/jsleep/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// get runs handler for a GET of target and returns the raw response, or
+// the HandlerError's status code if it returned one.
+func get(t *testing.T, handler server.Handler, target string) (string, int) {
+	req, err := request.RequestFromReader(strings.NewReader("GET " + target + " HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	if handlerErr := handler(response.Writer{Writer: &buf}, req); handlerErr != nil {
+		return "", int(handlerErr.Code)
+	}
+	return buf.String(), 0
+}
+
+var testFS = fstest.MapFS{
+	"index.html":       {Data: []byte("<h1>home</h1>")},
+	"css/site.css":     {Data: []byte("body{}")},
+	"docs/readme":      {Data: []byte("<html><body>sniffed</body></html>")},
+	"docs/a b&c.txt":   {Data: []byte("spaces")},
+	"docs/sub/x.json":  {Data: []byte("{}")},
+	"secret/.htaccess": {Data: []byte("nope")},
+}
+
+func TestFileServer(t *testing.T) {
+	handler := FileServer(testFS)
+
+	// Test: root serves index.html
+	resp, _ := get(t, handler, "/")
+	assert.Contains(t, resp, "Content-Type: text/html; charset=utf-8\r\n")
+	assert.True(t, strings.HasSuffix(resp, "<h1>home</h1>"))
+
+	// Test: content type by extension and by sniffing
+	resp, _ = get(t, handler, "/css/site.css")
+	assert.Contains(t, resp, "Content-Type: text/css; charset=utf-8\r\n")
+	resp, _ = get(t, handler, "/docs/readme")
+	assert.Contains(t, resp, "Content-Type: text/html; charset=utf-8\r\n")
+
+	// Test: escaped paths are decoded
+	resp, _ = get(t, handler, "/docs/a%20b&c.txt")
+	assert.True(t, strings.HasSuffix(resp, "spaces"))
+
+	// Test: traversal stays inside the root
+	resp, _ = get(t, handler, "/css/../../../index.html")
+	assert.True(t, strings.HasSuffix(resp, "<h1>home</h1>"))
+	resp, _ = get(t, handler, "/%2e%2e/%2e%2e/etc/passwd")
+	assert.Empty(t, resp)
+
+	// Test: missing files and directories without an index
+	_, code := get(t, handler, "/missing.txt")
+	assert.Equal(t, 404, code)
+	_, code = get(t, handler, "/docs/")
+	assert.Equal(t, 403, code)
+
+	// Test: directories without a trailing slash redirect
+	resp, _ = get(t, handler, "/docs")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 301 Moved Permanently\r\n"))
+	assert.Contains(t, resp, "Location: /docs/\r\n")
+
+	// Test: the query is kept and the path re-escaped
+	resp, _ = get(t, handler, "/docs/sub?x=1&y=%20")
+	assert.Contains(t, resp, "Location: /docs/sub/?x=1&y=%20\r\n")
+
+	// Test: a path that cleans to the root can't redirect to another host
+	for _, target := range []string{"//evil.example/..", "/%2fevil.example/.."} {
+		resp, _ = get(t, handler, target)
+		assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 301 Moved Permanently\r\n"), target)
+		assert.Contains(t, resp, "Location: /\r\n", target)
+	}
+
+	// Test: ranges are supported
+	req, err := request.RequestFromReader(strings.NewReader("GET /css/site.css HTTP/1.1\r\nRange: bytes=0-3\r\n\r\n"))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.Nil(t, handler(response.Writer{Writer: &buf}, req))
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 206 Partial Content\r\n"))
+	assert.True(t, strings.HasSuffix(buf.String(), "body"))
+}
+
+func TestDirectoryListing(t *testing.T) {
+	h := &Handler{Root: testFS, Prefix: "/files", ListDirectories: true}
+
+	// Test: listing escapes names and marks directories
+	resp, _ := get(t, h.Serve, "/files/docs/")
+	assert.Contains(t, resp, `<a href="a%20b&amp;c.txt">a b&amp;c.txt</a>`)
+	assert.Contains(t, resp, `<a href="sub/">sub/</a>`)
+	assert.Contains(t, resp, `<a href="../">../</a>`)
+
+	// Test: paths outside the prefix aren't served
+	_, code := get(t, h.Serve, "/filesdocs/")
+	assert.Equal(t, 404, code)
+	_, code = get(t, h.Serve, "/other/index.html")
+	assert.Equal(t, 404, code)
+}
+
+func TestSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "plain.txt"), []byte("plain"), 0o644))
+	require.NoError(t, os.Symlink(outside, filepath.Join(dir, "link")))
+
+	// Test: symlinks are refused by default
+	h := &Handler{Root: DirFS(dir)}
+	resp, _ := get(t, h.Serve, "/plain.txt")
+	assert.True(t, strings.HasSuffix(resp, "plain"))
+	_, code := get(t, h.Serve, "/link/secret.txt")
+	assert.Equal(t, 403, code)
+
+	// Test: and followed when allowed
+	h.FollowSymlinks = true
+	resp, _ = get(t, h.Serve, "/link/secret.txt")
+	assert.True(t, strings.HasSuffix(resp, "secret"))
+}
+
+func TestConditionalGet(t *testing.T) {
+	handler := FileServer(testFS)
+
+	// Test: files without a modtime get a content hash ETag
+	resp, _ := get(t, handler, "/css/site.css")
+	_, etag, found := strings.Cut(resp, "ETag: ")
+	require.True(t, found)
+	etag, _, _ = strings.Cut(etag, "\r\n")
+	assert.False(t, strings.HasPrefix(etag, "W/"))
+
+	// Test: revalidating with it gives a 304
+	req, err := request.RequestFromReader(strings.NewReader("GET /css/site.css HTTP/1.1\r\nIf-None-Match: " + etag + "\r\n\r\n"))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.Nil(t, handler(response.Writer{Writer: &buf}, req))
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 304 Not Modified\r\n"))
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n"))
+}
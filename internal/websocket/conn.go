@@ -0,0 +1,507 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+type MessageType int
+
+const (
+	TextMessage   MessageType = 1
+	BinaryMessage MessageType = 2
+)
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// Close codes from RFC 6455 section 7.4.1.
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005
+	CloseAbnormal        = 1006
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+)
+
+// DefaultMaxMessageSize is the message size limit of a Conn that doesn't
+// set one.
+const DefaultMaxMessageSize = 32 << 20
+
+// maxFrameSize caps a single frame's payload whatever the message limit, so
+// a length field can never ask for more than a sane allocation.
+const maxFrameSize = math.MaxInt32
+
+// readChunkSize bounds how much of a frame's payload is allocated ahead of
+// it arriving.
+const readChunkSize = 64 << 10
+
+// closeTimeout is how long Close waits for the peer to answer a close frame.
+const closeTimeout = 5 * time.Second
+
+// CloseError is returned by ReadMessage once the connection is closed,
+// carrying the code and reason from the close frame.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return "websocket closed: " + strconv.Itoa(e.Code)
+	}
+	return "websocket closed: " + strconv.Itoa(e.Code) + " " + e.Reason
+}
+
+var ErrCloseSent = errors.New("websocket: close already sent")
+
+// deflateTail is the end of an empty stored block, which permessage-deflate
+// strips from every compressed message.
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff}
+
+// Conn is a WebSocket connection. One goroutine may read while others
+// write; writes are serialized.
+type Conn struct {
+	// Subprotocol is the protocol agreed on during the handshake, if any.
+	Subprotocol string
+
+	// MaxMessageSize caps the size of a received message, after
+	// decompression. A larger message closes the connection with
+	// CloseMessageTooBig. 0 means DefaultMaxMessageSize and less than 0
+	// no limit, though no single frame may be over 2 GB.
+	MaxMessageSize int64
+
+	// FragmentSize splits written messages into frames carrying at most
+	// this many bytes. 0 sends every message as one frame.
+	FragmentSize int
+
+	conn     net.Conn
+	reader   *bufio.Reader
+	isServer bool
+	compress bool
+
+	writeMu   sync.Mutex
+	closeSent bool
+}
+
+func newConn(conn net.Conn, reader *bufio.Reader, isServer bool) *Conn {
+	return &Conn{conn: conn, reader: reader, isServer: isServer}
+}
+
+// maxMessageSize is the limit on received messages, MaxMessageSize with
+// its defaults applied.
+func (c *Conn) maxMessageSize() int64 {
+	switch {
+	case c.MaxMessageSize == 0:
+		return DefaultMaxMessageSize
+	case c.MaxMessageSize < 0:
+		return math.MaxInt64
+	}
+	return c.MaxMessageSize
+}
+
+// NetConn returns the underlying connection, e.g. to set deadlines.
+func (c *Conn) NetConn() net.Conn {
+	return c.conn
+}
+
+type frame struct {
+	fin        bool
+	compressed bool
+	opcode     byte
+	payload    []byte
+}
+
+// ReadMessage returns the next text or binary message, joining fragments
+// and answering pings on the way. Once the peer closes the connection, or
+// breaks the protocol, it returns a *CloseError and the connection is
+// closed.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	var messageType MessageType
+	var message []byte
+	compressed := false
+
+	for {
+		f, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch f.opcode {
+		case opPing:
+			if err := c.writeControl(opPong, f.payload); err != nil && err != ErrCloseSent {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			return 0, nil, c.handleClose(f.payload)
+		case opText, opBinary:
+			if messageType != 0 {
+				return 0, nil, c.fail(CloseProtocolError, "expected continuation frame")
+			}
+			messageType = MessageType(f.opcode)
+			compressed = f.compressed
+		case opContinuation:
+			if messageType == 0 {
+				return 0, nil, c.fail(CloseProtocolError, "unexpected continuation frame")
+			}
+			if f.compressed {
+				return 0, nil, c.fail(CloseProtocolError, "compressed continuation frame")
+			}
+		default:
+			return 0, nil, c.fail(CloseProtocolError, "unknown opcode "+strconv.Itoa(int(f.opcode)))
+		}
+
+		message = append(message, f.payload...)
+		if int64(len(message)) > c.maxMessageSize() {
+			return 0, nil, c.fail(CloseMessageTooBig, "message too big")
+		}
+		if f.fin {
+			break
+		}
+	}
+
+	if compressed {
+		var err error
+		message, err = c.decompress(message)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+	if messageType == TextMessage && !utf8.Valid(message) {
+		return 0, nil, c.fail(CloseInvalidPayload, "invalid UTF-8 in text message")
+	}
+	return messageType, message, nil
+}
+
+func (c *Conn) readFrame() (*frame, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.reader, head[:]); err != nil {
+		return nil, c.abnormal(err)
+	}
+
+	f := &frame{
+		fin:        head[0]&0x80 != 0,
+		compressed: head[0]&0x40 != 0,
+		opcode:     head[0] & 0x0f,
+	}
+	if head[0]&0x30 != 0 {
+		return nil, c.fail(CloseProtocolError, "reserved bits set")
+	}
+	if f.compressed && (!c.compress || f.opcode >= opClose) {
+		return nil, c.fail(CloseProtocolError, "unexpected compressed frame")
+	}
+
+	masked := head[1]&0x80 != 0
+	if masked != c.isServer {
+		if c.isServer {
+			return nil, c.fail(CloseProtocolError, "client frames must be masked")
+		}
+		return nil, c.fail(CloseProtocolError, "server frames must not be masked")
+	}
+
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return nil, c.abnormal(err)
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return nil, c.abnormal(err)
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+		if length>>63 != 0 {
+			return nil, c.fail(CloseProtocolError, "invalid frame length")
+		}
+	}
+
+	if f.opcode >= opClose && (!f.fin || length > 125) {
+		return nil, c.fail(CloseProtocolError, "invalid control frame")
+	}
+	// don't allocate for a frame that can't fit in a message anyway
+	if length > maxFrameSize || length > uint64(c.maxMessageSize()) {
+		return nil, c.fail(CloseMessageTooBig, "message too big")
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+			return nil, c.abnormal(err)
+		}
+	}
+	// the buffer grows as the payload arrives rather than taking the
+	// length field's word for it up front
+	var payload bytes.Buffer
+	payload.Grow(int(min(length, readChunkSize)))
+	if _, err := io.CopyN(&payload, c.reader, int64(length)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, c.abnormal(err)
+	}
+	f.payload = payload.Bytes()
+	if masked {
+		maskBytes(mask, f.payload)
+	}
+	return f, nil
+}
+
+func maskBytes(mask [4]byte, b []byte) {
+	for i := range b {
+		b[i] ^= mask[i%4]
+	}
+}
+
+// handleClose answers the peer's close frame and closes the connection.
+func (c *Conn) handleClose(payload []byte) error {
+	closeErr := &CloseError{Code: CloseNoStatus}
+	if len(payload) == 1 {
+		return c.fail(CloseProtocolError, "invalid close frame")
+	}
+	if len(payload) >= 2 {
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Reason = string(payload[2:])
+		if !validCloseCode(closeErr.Code) {
+			return c.fail(CloseProtocolError, "invalid close code")
+		}
+		if !utf8.Valid(payload[2:]) {
+			return c.fail(CloseInvalidPayload, "invalid UTF-8 in close reason")
+		}
+	}
+
+	replyCode := closeErr.Code
+	if replyCode == CloseNoStatus {
+		replyCode = CloseNormal
+	}
+	c.writeClose(replyCode, "")
+	c.conn.Close()
+	return closeErr
+}
+
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011:
+		return true
+	case code >= 3000 && code <= 4999:
+		// registered and private use
+		return true
+	}
+	return false
+}
+
+// fail closes the connection with code after the peer broke the protocol.
+func (c *Conn) fail(code int, reason string) error {
+	c.writeClose(code, reason)
+	c.conn.Close()
+	return &CloseError{Code: code, Reason: reason}
+}
+
+// abnormal reports a connection that went away without a close frame.
+func (c *Conn) abnormal(err error) error {
+	c.conn.Close()
+	return &CloseError{Code: CloseAbnormal, Reason: err.Error()}
+}
+
+func (c *Conn) decompress(data []byte) ([]byte, error) {
+	// the stripped tail plus a final empty block, so the reader ends cleanly
+	// instead of reporting an unexpected EOF
+	r := flate.NewReader(io.MultiReader(bytes.NewReader(data), bytes.NewReader(deflateTail),
+		bytes.NewReader([]byte{0x01, 0x00, 0x00, 0xff, 0xff})))
+	defer r.Close()
+
+	var reader io.Reader = r
+	if limit := c.maxMessageSize(); limit < math.MaxInt64 {
+		reader = io.LimitReader(r, limit+1)
+	}
+	message, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, c.fail(CloseProtocolError, "invalid compressed message")
+	}
+	if int64(len(message)) > c.maxMessageSize() {
+		return nil, c.fail(CloseMessageTooBig, "message too big")
+	}
+	return message, nil
+}
+
+func compressMessage(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), deflateTail), nil
+}
+
+// WriteMessage sends data as one message, compressed if permessage-deflate
+// was negotiated and split into frames of FragmentSize.
+func (c *Conn) WriteMessage(messageType MessageType, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return errors.New("websocket: unknown message type " + strconv.Itoa(int(messageType)))
+	}
+
+	compressed := false
+	if c.compress {
+		var err error
+		data, err = compressMessage(data)
+		if err != nil {
+			return err
+		}
+		compressed = true
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return ErrCloseSent
+	}
+
+	opcode := byte(messageType)
+	for {
+		fragment := data
+		if c.FragmentSize > 0 && len(fragment) > c.FragmentSize {
+			fragment = data[:c.FragmentSize]
+		}
+		data = data[len(fragment):]
+
+		fin := len(data) == 0
+		if err := c.writeFrame(fin, compressed, opcode, fragment); err != nil {
+			return err
+		}
+		if fin {
+			return nil
+		}
+		// only the first frame of a message carries its type and RSV1
+		opcode = opContinuation
+		compressed = false
+	}
+}
+
+// Ping sends a ping. The peer's pong is consumed by ReadMessage.
+func (c *Conn) Ping(data []byte) error {
+	return c.writeControl(opPing, data)
+}
+
+// Close starts the closing handshake: it sends a close frame with code and
+// reason, waits briefly for the peer's close frame, then closes the
+// connection. Don't call it while another goroutine is in ReadMessage;
+// that goroutine sees the peer's close instead.
+func (c *Conn) Close(code int, reason string) error {
+	if err := c.writeClose(code, reason); err != nil {
+		return c.conn.Close()
+	}
+
+	c.conn.SetReadDeadline(time.Now().Add(closeTimeout))
+	for {
+		f, err := c.readFrame()
+		if err != nil || f.opcode == opClose {
+			break
+		}
+	}
+	return c.conn.Close()
+}
+
+func (c *Conn) writeClose(code int, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+	if len(payload) > 125 {
+		payload = payload[:125]
+	}
+	err := c.writeControl(opClose, payload)
+
+	c.writeMu.Lock()
+	c.closeSent = true
+	c.writeMu.Unlock()
+	return err
+}
+
+func (c *Conn) writeControl(opcode byte, payload []byte) error {
+	if len(payload) > 125 {
+		return errors.New("websocket: control frame payload too long")
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return ErrCloseSent
+	}
+	return c.writeFrame(true, false, opcode, payload)
+}
+
+// writeFrame writes a single frame. The caller holds writeMu.
+func (c *Conn) writeFrame(fin bool, compressed bool, opcode byte, payload []byte) error {
+	head := make([]byte, 0, 14+len(payload))
+
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	if compressed {
+		first |= 0x40
+	}
+	head = append(head, first)
+
+	var maskBit byte
+	if !c.isServer {
+		maskBit = 0x80
+	}
+	switch {
+	case len(payload) <= 125:
+		head = append(head, maskBit|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		head = append(head, maskBit|126)
+		head = binary.BigEndian.AppendUint16(head, uint16(len(payload)))
+	default:
+		head = append(head, maskBit|127)
+		head = binary.BigEndian.AppendUint64(head, uint64(len(payload)))
+	}
+
+	if c.isServer {
+		_, err := c.conn.Write(append(head, payload...))
+		return err
+	}
+
+	// clients mask every frame with a fresh random key
+	var mask [4]byte
+	if _, err := rand.Read(mask[:]); err != nil {
+		return err
+	}
+	head = append(head, mask[:]...)
+	masked := append(head, payload...)
+	maskBytes(mask, masked[len(head):])
+	_, err := c.conn.Write(masked)
+	return err
+}
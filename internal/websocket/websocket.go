@@ -0,0 +1,185 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/jsleep/httpfromtcp/internal/headers"
+	"github.com/jsleep/httpfromtcp/internal/request"
+	"github.com/jsleep/httpfromtcp/internal/response"
+	"github.com/jsleep/httpfromtcp/internal/server"
+)
+
+// acceptGUID is appended to the client's key to prove the server
+// understood the handshake (RFC 6455 section 1.3).
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Upgrader turns requests into WebSocket connections.
+type Upgrader struct {
+	// Subprotocols the server speaks, most preferred first. The first one
+	// the client also offers is picked.
+	Subprotocols []string
+
+	// CheckOrigin decides whether a browser on another origin may connect.
+	// When nil, requests with an Origin header whose host differs from the
+	// Host header are refused.
+	CheckOrigin func(req *request.Request) bool
+
+	// EnableCompression accepts the permessage-deflate extension when the
+	// client offers it.
+	EnableCompression bool
+
+	// MaxMessageSize is copied to every Conn. 0 means
+	// DefaultMaxMessageSize; less than 0 lifts the limit.
+	MaxMessageSize int64
+}
+
+// Upgrade completes the opening handshake and takes over the connection.
+// When the request isn't an acceptable handshake, Upgrade answers it with
+// an error response itself and returns the error; the handler should just
+// return.
+func (u *Upgrader) Upgrade(w *response.Writer, req *request.Request) (*Conn, error) {
+	if handlerError := u.checkHandshake(req); handlerError != nil {
+		if handlerError.Code == 426 {
+			w.OnWriteHeaders(func(w *response.Writer, h headers.Headers) {
+				h.Set("Sec-WebSocket-Version", "13")
+			})
+		}
+		handlerError.Write(*w, req)
+		return nil, errors.New("websocket handshake failed: " + handlerError.Message)
+	}
+
+	key := req.Headers.Get("Sec-WebSocket-Key")
+	h := headers.Headers{
+		"Upgrade":              "websocket",
+		"Connection":           "Upgrade",
+		"Sec-WebSocket-Accept": acceptKey(key),
+	}
+	subprotocol := u.selectSubprotocol(req)
+	if subprotocol != "" {
+		h.Set("Sec-WebSocket-Protocol", subprotocol)
+	}
+	compress := u.EnableCompression && acceptDeflate(req.Headers.Get("Sec-WebSocket-Extensions"))
+	if compress {
+		// without context takeover every message is compressed on its own,
+		// so neither side has to keep a window around between messages
+		h.Set("Sec-WebSocket-Extensions", "permessage-deflate; server_no_context_takeover; client_no_context_takeover")
+	}
+
+	// take the connection first, so a failure can still be answered with
+	// an error rather than after a 101
+	netConn, buffered, err := w.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	if err := w.WriteStatusLine(101); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	// a client may send its first frames right behind the handshake
+	reader := bufio.NewReader(io.MultiReader(bytes.NewReader(buffered), netConn))
+	conn := newConn(netConn, reader, true)
+	conn.Subprotocol = subprotocol
+	conn.MaxMessageSize = u.MaxMessageSize
+	conn.compress = compress
+	return conn, nil
+}
+
+func (u *Upgrader) checkHandshake(req *request.Request) *server.HandlerError {
+	if req.RequestLine.Method != "GET" {
+		return &server.HandlerError{Code: 405, Message: "websocket handshake must be a GET request"}
+	}
+	if !hasToken(req.Headers.Get("Connection"), "upgrade") || !hasToken(req.Headers.Get("Upgrade"), "websocket") {
+		return &server.HandlerError{Code: 400, Message: "not a websocket handshake"}
+	}
+	if req.Headers.Get("Sec-WebSocket-Version") != "13" {
+		return &server.HandlerError{Code: 426, Message: "unsupported websocket version"}
+	}
+	key, err := base64.StdEncoding.DecodeString(req.Headers.Get("Sec-WebSocket-Key"))
+	if err != nil || len(key) != 16 {
+		return &server.HandlerError{Code: 400, Message: "invalid Sec-WebSocket-Key"}
+	}
+	checkOrigin := u.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(req) {
+		return &server.HandlerError{Code: 403, Message: "origin not allowed"}
+	}
+	return nil
+}
+
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// hasToken reports whether the comma separated header value contains token.
+func hasToken(value string, token string) bool {
+	for _, part := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(part), token) {
+			return true
+		}
+	}
+	return false
+}
+
+func sameOrigin(req *request.Request) bool {
+	origin := req.Headers.Get("Origin")
+	if origin == "" {
+		// not a browser, origins don't apply
+		return true
+	}
+	_, host, found := strings.Cut(origin, "://")
+	return found && strings.EqualFold(host, req.Headers.Get("Host"))
+}
+
+func (u *Upgrader) selectSubprotocol(req *request.Request) string {
+	offered := req.Headers.Get("Sec-WebSocket-Protocol")
+	for _, protocol := range u.Subprotocols {
+		if hasToken(offered, protocol) {
+			return protocol
+		}
+	}
+	return ""
+}
+
+// acceptDeflate reports whether one of the permessage-deflate offers in a
+// Sec-WebSocket-Extensions header can be accepted (RFC 7692).
+func acceptDeflate(extensions string) bool {
+	for _, offer := range strings.Split(extensions, ",") {
+		params := strings.Split(offer, ";")
+		if strings.TrimSpace(params[0]) != "permessage-deflate" {
+			continue
+		}
+		acceptable := true
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			value = strings.Trim(value, `"`)
+			switch name {
+			case "server_no_context_takeover", "client_no_context_takeover", "client_max_window_bits":
+			case "server_max_window_bits":
+				// compress/flate always uses a 32 KB window
+				if value != "15" {
+					acceptable = false
+				}
+			default:
+				acceptable = false
+			}
+		}
+		if acceptable {
+			return true
+		}
+	}
+	return false
+}
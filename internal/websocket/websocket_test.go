@@ -0,0 +1,204 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/jsleep/httpfromtcp/internal/request"
+	"github.com/jsleep/httpfromtcp/internal/response"
+	"github.com/jsleep/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startEcho(t *testing.T, upgrader *Upgrader) *server.Server {
+	srv, err := server.Serve(0, func(w response.Writer, req *request.Request) *server.HandlerError {
+		conn, err := upgrader.Upgrade(&w, req)
+		if err != nil {
+			return nil
+		}
+		for {
+			messageType, message, err := conn.ReadMessage()
+			if err != nil {
+				return nil
+			}
+			if err := conn.WriteMessage(messageType, message); err != nil {
+				return nil
+			}
+		}
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+// handshake sends an opening handshake with extra header lines and returns
+// the response head and a client connection reading after it.
+func handshake(t *testing.T, srv *server.Server, extra string) (string, *Conn) {
+	netConn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { netConn.Close() })
+
+	_, err = netConn.Write([]byte("GET /chat HTTP/1.1\r\nHost: localhost\r\n" +
+		"Upgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" + extra + "\r\n"))
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(netConn)
+	var head strings.Builder
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			break
+		}
+		head.WriteString(line)
+	}
+	conn := newConn(netConn, reader, false)
+	conn.compress = strings.Contains(head.String(), "permessage-deflate")
+	return head.String(), conn
+}
+
+func TestHandshake(t *testing.T) {
+	srv := startEcho(t, &Upgrader{Subprotocols: []string{"v2.chat", "chat"}})
+
+	// Test: the accept key from RFC 6455's example and the agreed protocol
+	head, _ := handshake(t, srv, "Sec-WebSocket-Version: 13\r\nSec-WebSocket-Protocol: chat, v2.chat\r\n")
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 101 Switching Protocols\r\n"))
+	assert.Contains(t, head, "Sec-WebSocket-Accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=\r\n")
+	assert.Contains(t, head, "Upgrade: websocket\r\n")
+	assert.Contains(t, head, "Sec-WebSocket-Protocol: v2.chat\r\n")
+	assert.NotContains(t, head, "Sec-WebSocket-Extensions")
+
+	// Test: other versions are told which one to use
+	head, _ = handshake(t, srv, "Sec-WebSocket-Version: 8\r\n")
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 426 Upgrade Required\r\n"))
+	assert.Contains(t, head, "Sec-WebSocket-Version: 13\r\n")
+
+	// Test: browsers on another origin are refused
+	head, _ = handshake(t, srv, "Sec-WebSocket-Version: 13\r\nOrigin: https://evil.test\r\n")
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 403 Forbidden\r\n"))
+}
+
+func TestEcho(t *testing.T) {
+	srv := startEcho(t, &Upgrader{})
+	_, client := handshake(t, srv, "Sec-WebSocket-Version: 13\r\n")
+
+	// Test: text and binary messages, including extended lengths
+	require.NoError(t, client.WriteMessage(TextMessage, []byte("hello")))
+	messageType, message, err := client.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, TextMessage, messageType)
+	assert.Equal(t, "hello", string(message))
+
+	for _, size := range []int{200, 70000} {
+		payload := bytes.Repeat([]byte{0xfe}, size)
+		require.NoError(t, client.WriteMessage(BinaryMessage, payload))
+		messageType, message, err = client.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, BinaryMessage, messageType)
+		assert.Equal(t, payload, message)
+	}
+
+	// Test: fragments are joined, with a ping in between answered
+	client.FragmentSize = 3
+	require.NoError(t, client.Ping([]byte("are you there")))
+	require.NoError(t, client.WriteMessage(TextMessage, []byte("fragmented message")))
+	_, message, err = client.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, "fragmented message", string(message))
+
+	// Test: the server answers a close with the same code
+	require.NoError(t, client.writeClose(CloseGoingAway, "bye"))
+	client.closeSent = false
+	_, _, err = client.ReadMessage()
+	var closeErr *CloseError
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, CloseGoingAway, closeErr.Code)
+}
+
+func TestCompression(t *testing.T) {
+	srv := startEcho(t, &Upgrader{EnableCompression: true})
+
+	head, client := handshake(t, srv, "Sec-WebSocket-Version: 13\r\nSec-WebSocket-Extensions: permessage-deflate; client_max_window_bits\r\n")
+	assert.Contains(t, head, "Sec-WebSocket-Extensions: permessage-deflate; server_no_context_takeover; client_no_context_takeover\r\n")
+
+	// Test: compressed messages both ways
+	text := strings.Repeat("compress me ", 100)
+	for range 2 {
+		require.NoError(t, client.WriteMessage(TextMessage, []byte(text)))
+		_, message, err := client.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, text, string(message))
+	}
+
+	// Test: offers that need a smaller window are declined
+	head, _ = handshake(t, srv, "Sec-WebSocket-Version: 13\r\nSec-WebSocket-Extensions: permessage-deflate; server_max_window_bits=10\r\n")
+	assert.NotContains(t, head, "Sec-WebSocket-Extensions")
+}
+
+func TestProtocolErrors(t *testing.T) {
+	srv := startEcho(t, &Upgrader{MaxMessageSize: 16})
+
+	// Test: messages over the limit close the connection with 1009
+	_, client := handshake(t, srv, "Sec-WebSocket-Version: 13\r\n")
+	require.NoError(t, client.WriteMessage(BinaryMessage, make([]byte, 17)))
+	_, _, err := client.ReadMessage()
+	var closeErr *CloseError
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, CloseMessageTooBig, closeErr.Code)
+
+	// Test: so do fragments that add up to more than the limit
+	_, client = handshake(t, srv, "Sec-WebSocket-Version: 13\r\n")
+	client.FragmentSize = 10
+	require.NoError(t, client.WriteMessage(BinaryMessage, make([]byte, 20)))
+	_, _, err = client.ReadMessage()
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, CloseMessageTooBig, closeErr.Code)
+
+	// Test: unmasked client frames are a protocol error
+	_, client = handshake(t, srv, "Sec-WebSocket-Version: 13\r\n")
+	client.isServer = true
+	require.NoError(t, client.WriteMessage(TextMessage, []byte("hi")))
+	client.isServer = false
+	_, _, err = client.ReadMessage()
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, CloseProtocolError, closeErr.Code)
+
+	// Test: text must be UTF-8
+	_, client = handshake(t, srv, "Sec-WebSocket-Version: 13\r\n")
+	require.NoError(t, client.WriteMessage(TextMessage, []byte{0xff, 0xfe}))
+	_, _, err = client.ReadMessage()
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, CloseInvalidPayload, closeErr.Code)
+}
+
+func TestOversizedFrameLength(t *testing.T) {
+	for _, upgrader := range []*Upgrader{{}, {MaxMessageSize: -1}} {
+		srv := startEcho(t, upgrader)
+
+		for _, length := range []uint64{1 << 62, 1 << 40} {
+			// Test: a frame header claiming a huge payload is refused before anything is allocated
+			_, client := handshake(t, srv, "Sec-WebSocket-Version: 13\r\n")
+			head := []byte{0x82, 0x80 | 127, 0, 0, 0, 0, 0, 0, 0, 0, 1, 2, 3, 4}
+			binary.BigEndian.PutUint64(head[2:10], length)
+			_, err := client.conn.Write(head)
+			require.NoError(t, err)
+			_, _, err = client.ReadMessage()
+			var closeErr *CloseError
+			require.ErrorAs(t, err, &closeErr)
+			assert.Equal(t, CloseMessageTooBig, closeErr.Code)
+		}
+
+		// Test: and the server is still there for the next client
+		_, client := handshake(t, srv, "Sec-WebSocket-Version: 13\r\n")
+		require.NoError(t, client.WriteMessage(TextMessage, []byte("still here")))
+		_, message, err := client.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, "still here", string(message))
+	}
+}